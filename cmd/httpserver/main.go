@@ -1,111 +1,135 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"httpfromtcp/internal/headers"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/router"
 	"httpfromtcp/internal/server"
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
-const port = 42069
+const (
+	port            = 42069
+	shutdownTimeout = 10 * time.Second
+)
 
 func main() {
-	server, err := server.Serve(port, testHandler)
+	handler := server.Chain(routes().Handler(), server.Recover(nil), server.RequestID(), server.Timing(nil))
+	srv, err := server.Start(server.Config{
+		Addr:       fmt.Sprintf(":%d", port),
+		Handler:    handler,
+		ServerName: "httpfromtcp",
+		Timeouts: server.Timeouts{
+			ReadHeaderTimeout: 10 * time.Second,
+			WriteTimeout:      time.Minute,
+			IdleTimeout:       2 * time.Minute,
+		},
+	})
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
 	log.Println("Server started on port", port)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Server stopped with requests still running: %v", err)
+		return
+	}
 	log.Println("Server gracefully stopped")
 }
 
-func testHandler(w *response.Writer, req *request.Request) *server.HandlerError {
+func routes() *router.Router {
+	r := router.New()
+	r.Handle(http.MethodGet, "/yourproblem", pageHandler(response.HTTPBadRequest, badRequest))
+	r.Handle(http.MethodGet, "/myproblem", pageHandler(response.HTTPInternalServerError, internalError))
+	r.Handle(http.MethodGet, "/video", fileHandler("assets/vim.mp4"))
+	r.Handle(http.MethodGet, httpbinPrefix+"/", chunkHandler)
+	r.Handle(http.MethodGet, "/", pageHandler(response.HTTPOk, okRequest))
+	return r
+}
 
-	var buffer bytes.Buffer
-	var err error
-	var n int
-	status := response.HTTPOk
-	switch req.RequestLine.RequestTarget {
-	case "/yourproblem":
-		status = response.HTTPBadRequest
-		n, err = buffer.Write([]byte(badRequest))
-	case "/myproblem":
-		status = response.HTTPInternalServerError
-		n, err = buffer.Write([]byte(internalError))
-	case "/video":
-		return videoHandler(w, req)
-	default:
-		if strings.HasPrefix(req.RequestLine.RequestTarget, httpbinPrefix) {
-			return chunkHandler(w, req)
+func pageHandler(status response.StatusCode, page string) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.Header().SetContentType(headers.TextHTML)
+		if err := w.WriteHeader(status); err != nil {
+			return &server.HandlerError{Status: response.HTTPInternalServerError, Message: err.Error()}
+		}
+		if _, err := io.WriteString(w, page); err != nil {
+			fmt.Printf("Unable to write body for target %s: %s\n", req.RequestLine.RequestTarget, err.Error())
 		}
-		n, err = buffer.Write([]byte(okRequest))
-	}
-	if err != nil {
-		return &server.HandlerError{Status: response.HTTPInternalServerError, Message: err.Error()}
-	}
-	err = w.WriteStatusLine(status)
-	if err != nil {
-		fmt.Printf("Unable to write status line for target %s: %s\n", req.RequestLine.RequestTarget, err.Error())
-		return &server.HandlerError{Status: response.HTTPInternalServerError, Message: err.Error()}
-	}
-	header := response.GetDefaultHeaders(n)
-	header.SetContextType(headers.ContentTypeTextHTML)
-	err = w.WriteHeaders(header)
-	if err != nil {
-		fmt.Printf("Unable to write header for target %s: %s\n", req.RequestLine.RequestTarget, err.Error())
-		//return &server.HandlerError{Status: response.HTTPInternalServerError, Message: err.Error()}
-		return nil
-	}
-	_, err = w.WriteBody(buffer.Bytes())
-	if err != nil {
-		fmt.Printf("Unable to write body for target %s: %s\n", req.RequestLine.RequestTarget, err.Error())
-		//return &server.HandlerError{Status: response.HTTPInternalServerError, Message: err.Error()}
 		return nil
 	}
-	return nil
 }
-func videoHandler(w *response.Writer, req *request.Request) *server.HandlerError {
-	video, err := os.ReadFile("assets/vim.mp4")
-	if err != nil {
-		fmt.Printf("error reading video: %s", err.Error())
+
+// fileHandler serves the file at path with the media type registered for its
+// extension.
+func fileHandler(path string) server.Handler {
+	mediaType, ok := headers.MediaTypeByExtension(filepath.Ext(path))
+	if !ok {
+		mediaType = headers.OctetStream
+	}
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return &server.HandlerError{Status: response.HTTPNotFound, Message: "Not Found"}
+		} else if err != nil {
+			fmt.Printf("error reading %s: %s\n", path, err.Error())
+			return &server.HandlerError{Status: response.HTTPInternalServerError, Message: "Internal Server Error"}
+		}
+		w.Header().SetContentType(mediaType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
 		return nil
 	}
-	w.WriteStatusLine(response.HTTPOk)
-	header := response.GetDefaultHeaders(len(video))
-	header.SetContextType(headers.ContentTypeVideo)
-	w.WriteHeaders(header)
-	w.WriteBody(video)
-	return nil
 }
 
 func chunkHandler(w *response.Writer, req *request.Request) *server.HandlerError {
-	targetPath := strings.TrimPrefix(req.RequestLine.RequestTarget, httpbinPrefix)
-	URL := httpbinURL + targetPath
-	resp, err := http.Get(URL)
+	// built from the decoded path, which the router matched, so nothing in
+	// the target can change the host
+	upstream := url.URL{
+		Scheme:   "https",
+		Host:     httpbinHost,
+		Path:     strings.TrimPrefix(req.URL.Path, httpbinPrefix),
+		RawQuery: req.URL.RawQuery,
+	}
+	resp, err := http.Get(upstream.String())
 	if err != nil {
-		return &server.HandlerError{Status: response.HTTPInternalServerError, Message: err.Error()}
+		return &server.HandlerError{Status: response.HTTPBadGateway, Message: "Bad Gateway"}
+	}
+	defer resp.Body.Close()
+	status := response.StatusCode(resp.StatusCode)
+	if !status.AllowsBody() {
+		w.WriteHeader(status)
+		return nil
 	}
-	err = w.WriteStatusLine(response.HTTPOk)
+	err = w.WriteStatusLine(status)
 	if err != nil {
 		fmt.Printf("Unable to write status line for target %s: %s\n", req.RequestLine.RequestTarget, err.Error())
 		return &server.HandlerError{Status: response.HTTPInternalServerError, Message: err.Error()}
 	}
 	header := response.GetDefaultHeaders(-1)
-	header.SetContextType(headers.ContentTypeTextHTML)
-	header.AddHeader("Transfer-Encoding", "chunked")
+	header.SetContentType(headers.TextHTML)
+	header.Set("Transfer-Encoding", "chunked")
 	trailerNames := [2]string{hashTrailer, lengthTrailer}
 	header.AddTrailers(trailerNames[:])
 	err = w.WriteHeaders(header)
@@ -113,27 +137,17 @@ func chunkHandler(w *response.Writer, req *request.Request) *server.HandlerError
 		fmt.Printf("Unable to write header for target %s: %s\n", req.RequestLine.RequestTarget, err.Error())
 		return nil
 	}
-	buffer := make([]byte, 1024)
 	hasher := sha256.New()
-	total := 0
-	for n, err := resp.Body.Read(buffer); err == nil; n, err = resp.Body.Read(buffer) {
-		if n > 0 {
-			total += n
-			w.WriteChunkedBody(buffer[:n])
-			hasher.Write(buffer[:n])
-		}
-	}
+	total, _ := io.Copy(io.MultiWriter(w, hasher), resp.Body)
 	w.WriteChunkedBodyDone()
 	hash := hasher.Sum(nil)
 	trailers := headers.NewHeaders()
 	//fmt.Println("what ", fmt.Sprintf("%x", hash), " ", fmt.Sprintf("%d", total))
-	trailers.AddHeader(hashTrailer, fmt.Sprintf("%x", hash))
-	trailers.AddHeader(lengthTrailer, fmt.Sprintf("%d", total))
+	trailers.Set(hashTrailer, fmt.Sprintf("%x", hash))
+	trailers.Set(lengthTrailer, fmt.Sprintf("%d", total))
 	err = w.WriteTrailers(trailers)
-	if err != nil {
+	if err != nil && !errors.Is(err, response.ErrTrailersNotAccepted) {
 		fmt.Println("ERROR: ", err.Error())
-	} else {
-		//fmt.Println("NO ERROR")
 	}
 	return nil
 }
@@ -143,7 +157,7 @@ const (
 	internalError = "<html><head><title>500 Internal Server Error</title></head><body><h1>Internal Server Error</h1><p>Okay, you know what? This one is on me.</p></body></html>"
 	okRequest     = "<html><head><title>200 OK</title></head><body><h1>Success!</h1><p>Your request was an absolute banger.</p></body></html>"
 	httpbinPrefix = "/httpbin"
-	httpbinURL    = "https://httpbin.org"
+	httpbinHost   = "httpbin.org"
 	hashTrailer   = "X-Content-Sha256"
 	lengthTrailer = "X-Content-Length"
 )
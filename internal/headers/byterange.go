@@ -0,0 +1,80 @@
+package headers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ByteRange is one range of a Range field. First is -1 for a suffix range,
+// the last Last bytes, and Last is -1 for a range running to the end.
+type ByteRange struct {
+	First int64
+	Last  int64
+}
+
+const bytesUnit = "bytes"
+
+// ParseRange parses a Range value such as "bytes=0-499, -500, 9500-".
+func ParseRange(value string) ([]ByteRange, error) {
+	unit, set, found := strings.Cut(strings.TrimSpace(value), "=")
+	if !found || !strings.EqualFold(strings.TrimSpace(unit), bytesUnit) {
+		return nil, fmt.Errorf("unsupported range unit in '%s'", value)
+	}
+	var ranges []ByteRange
+	for _, spec := range strings.Split(set, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		first, last, found := strings.Cut(spec, "-")
+		if !found || (first == "" && last == "") {
+			return nil, fmt.Errorf("invalid range '%s'", spec)
+		}
+		r := ByteRange{First: -1, Last: -1}
+		var err error
+		if first != "" {
+			if r.First, err = parseRangeInt(first); err != nil {
+				return nil, err
+			}
+		}
+		if last != "" {
+			if r.Last, err = parseRangeInt(last); err != nil {
+				return nil, err
+			}
+		}
+		if r.First >= 0 && r.Last >= 0 && r.Last < r.First {
+			return nil, fmt.Errorf("invalid range '%s': last before first", spec)
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no ranges in '%s'", value)
+	}
+	return ranges, nil
+}
+
+func parseRangeInt(s string) (int64, error) {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid range position '%s'", s)
+		}
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// Resolve returns the offset and length r selects in a representation of
+// size bytes, or false if it selects nothing.
+func (r ByteRange) Resolve(size int64) (offset, length int64, ok bool) {
+	switch {
+	case r.First < 0:
+		length = min(r.Last, size)
+		return size - length, length, length > 0
+	case r.First >= size:
+		return 0, 0, false
+	case r.Last < 0 || r.Last >= size:
+		return r.First, size - r.First, true
+	default:
+		return r.First, r.Last - r.First + 1, true
+	}
+}
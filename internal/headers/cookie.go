@@ -0,0 +1,157 @@
+package headers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cookie is a cookie sent by the client in Cookie, or one the server sets
+// with Set-Cookie. Only Name and Value are filled in for the former.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Expires time.Time // zero for none
+	// MaxAge is the lifetime in seconds. 0 leaves it out, a negative value
+	// sends Max-Age=0 to delete the cookie now.
+	MaxAge      int
+	Domain      string
+	Path        string
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+type SameSite int
+
+const (
+	SameSiteDefault    SameSite = 0
+	SameSiteLax        SameSite = 1
+	SameSiteStrict     SameSite = 2
+	SameSiteNone       SameSite = 3
+	cookieFieldName             = "Cookie"
+	setCookieFieldName          = "Set-Cookie"
+)
+
+var sameSiteNames = map[SameSite]string{
+	SameSiteLax:    "Lax",
+	SameSiteStrict: "Strict",
+	SameSiteNone:   "None",
+}
+
+// ParseCookies parses the name=value pairs of a Cookie field, skipping any
+// that are malformed.
+func ParseCookies(value string) []Cookie {
+	var cookies []Cookie
+	for _, pair := range strings.Split(value, ";") {
+		name, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || !IsToken(name) {
+			continue
+		}
+		val, ok := unquoteCookieValue(val)
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, Cookie{Name: name, Value: val})
+	}
+	return cookies
+}
+
+// Cookies returns the cookies in every Cookie field.
+func (h *Headers) Cookies() []Cookie {
+	var cookies []Cookie
+	for _, value := range h.Values(cookieFieldName) {
+		cookies = append(cookies, ParseCookies(value)...)
+	}
+	return cookies
+}
+
+// AddCookie adds a Set-Cookie field for c, after any already there.
+func (h *Headers) AddCookie(c Cookie) error {
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	h.Add(setCookieFieldName, c.String())
+	return nil
+}
+
+// Valid checks that c can be sent in Set-Cookie without breaking the field or
+// being rejected by browsers.
+func (c Cookie) Valid() error {
+	if !IsToken(c.Name) {
+		return fmt.Errorf("invalid cookie name '%s'", c.Name)
+	}
+	if _, ok := unquoteCookieValue(c.Value); !ok {
+		return fmt.Errorf("invalid value for cookie %s", c.Name)
+	}
+	for _, attr := range []string{c.Domain, c.Path} {
+		if strings.ContainsFunc(attr, func(r rune) bool { return r == ';' || r < ' ' || r == 0x7f }) {
+			return fmt.Errorf("invalid attribute '%s' for cookie %s", attr, c.Name)
+		}
+	}
+	if _, ok := sameSiteNames[c.SameSite]; !ok && c.SameSite != SameSiteDefault {
+		return fmt.Errorf("invalid SameSite %d for cookie %s", c.SameSite, c.Name)
+	}
+	if (c.SameSite == SameSiteNone || c.Partitioned) && !c.Secure {
+		return fmt.Errorf("cookie %s must be Secure for SameSite=None or Partitioned", c.Name)
+	}
+	return nil
+}
+
+// String formats c as a Set-Cookie value.
+func (c Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	b.WriteString(c.Value)
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=")
+		b.WriteString(FormatHTTPDate(c.Expires))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=")
+		b.WriteString(strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=")
+		b.WriteString(c.Domain)
+	}
+	if c.Path != "" {
+		b.WriteString("; Path=")
+		b.WriteString(c.Path)
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if name, ok := sameSiteNames[c.SameSite]; ok {
+		b.WriteString("; SameSite=")
+		b.WriteString(name)
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// unquoteCookieValue strips the optional quotes around a cookie value and
+// reports whether it only holds characters a cookie value may contain.
+func unquoteCookieValue(value string) (string, bool) {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return "", false
+		}
+	}
+	return value, true
+}
@@ -0,0 +1,31 @@
+package headers
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeFormat is the preferred HTTP-date format, IMF-fixdate, always in GMT.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// every format an HTTP-date may arrive in, the preferred one first
+var httpDateFormats = []string{
+	TimeFormat,
+	"Monday, 02-Jan-06 15:04:05 GMT", // RFC 850
+	"Mon Jan _2 15:04:05 2006",       // asctime
+}
+
+// FormatHTTPDate formats t as an IMF-fixdate.
+func FormatHTTPDate(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
+
+// ParseHTTPDate parses an HTTP-date in any of its three legal formats.
+func ParseHTTPDate(value string) (time.Time, error) {
+	for _, format := range httpDateFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid HTTP-date '%s'", value)
+}
@@ -0,0 +1,116 @@
+package headers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ETag is an entity tag, the opaque validator in ETag, If-Match and
+// If-None-Match. Opaque does not include the quotes.
+type ETag struct {
+	Opaque string
+	Weak   bool
+}
+
+// ETagList is the value of If-Match or If-None-Match: either "*", matching
+// any current representation, or a list of tags.
+type ETagList struct {
+	Any  bool
+	Tags []ETag
+}
+
+const weakPrefix = "W/"
+
+// ParseETag parses a single entity tag such as "xyzzy" or W/"xyzzy".
+func ParseETag(value string) (ETag, error) {
+	tag, rest, err := cutETag(strings.TrimSpace(value))
+	if err != nil {
+		return ETag{}, err
+	}
+	if rest != "" {
+		return ETag{}, fmt.Errorf("invalid entity tag '%s'", value)
+	}
+	return tag, nil
+}
+
+// ParseETagList parses "*" or a comma-separated list of entity tags.
+func ParseETagList(value string) (ETagList, error) {
+	rest := strings.TrimSpace(value)
+	if rest == "*" {
+		return ETagList{Any: true}, nil
+	}
+	var list ETagList
+	for rest != "" {
+		if rest[0] == ',' {
+			rest = strings.TrimLeft(rest[1:], " \t")
+			continue
+		}
+		tag, after, err := cutETag(rest)
+		if err != nil {
+			return ETagList{}, err
+		}
+		list.Tags = append(list.Tags, tag)
+		rest = strings.TrimLeft(after, " \t")
+		if rest != "" && rest[0] != ',' {
+			return ETagList{}, fmt.Errorf("invalid entity tag list '%s'", value)
+		}
+	}
+	if len(list.Tags) == 0 {
+		return ETagList{}, fmt.Errorf("empty entity tag list")
+	}
+	return list, nil
+}
+
+// cutETag reads one entity tag off the front of s.
+func cutETag(s string) (ETag, string, error) {
+	var tag ETag
+	if strings.HasPrefix(s, weakPrefix) {
+		tag.Weak = true
+		s = s[len(weakPrefix):]
+	}
+	if !strings.HasPrefix(s, `"`) {
+		return ETag{}, "", fmt.Errorf("entity tag not quoted in '%s'", s)
+	}
+	end := strings.IndexByte(s[1:], '"')
+	if end < 0 {
+		return ETag{}, "", fmt.Errorf("unterminated entity tag '%s'", s)
+	}
+	tag.Opaque = s[1 : end+1]
+	for _, c := range []byte(tag.Opaque) {
+		if c <= ' ' || c == 0x7f {
+			return ETag{}, "", fmt.Errorf("invalid character in entity tag '%s'", tag.Opaque)
+		}
+	}
+	return tag, s[end+2:], nil
+}
+
+func (t ETag) String() string {
+	if t.Weak {
+		return weakPrefix + `"` + t.Opaque + `"`
+	}
+	return `"` + t.Opaque + `"`
+}
+
+// StrongMatch reports whether t and other are the same strong validator.
+func (t ETag) StrongMatch(other ETag) bool {
+	return !t.Weak && !other.Weak && t.Opaque == other.Opaque
+}
+
+// WeakMatch reports whether t and other match ignoring weakness, the
+// comparison used for If-None-Match.
+func (t ETag) WeakMatch(other ETag) bool {
+	return t.Opaque == other.Opaque
+}
+
+// WeakMatch reports whether current matches the list by weak comparison.
+func (l ETagList) WeakMatch(current ETag) bool {
+	if l.Any {
+		return true
+	}
+	for _, tag := range l.Tags {
+		if tag.WeakMatch(current) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,129 +2,345 @@ package headers
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"iter"
+	"slices"
 	"strings"
-	"unicode"
 )
 
-type Headers map[string]string
+// Headers holds header fields in the order they were added, keeping every
+// value of a repeated field and the casing each name arrived with. Lookups
+// ignore case.
+type Headers struct {
+	fields []field
+}
 
-type ContentType int
+type field struct {
+	name  string
+	value string
+}
 
 const (
-	lineEndStr                       = "\r\n"
-	lineEndLen                       = len(lineEndStr)
-	sep                              = ":"
-	ContentTypeTextPlain ContentType = 0
-	ContentTypeTextHTML  ContentType = 1
-	ContentTypeVideo     ContentType = 2
-	trailerFieldName                 = "Trailer"
+	sep              = ":"
+	trailerFieldName = "Trailer"
 )
 
-var lineEndBytes = []byte(lineEndStr)
+// ErrFieldNotPresent is returned when looking up a field the message does not
+// have.
+var ErrFieldNotPresent = errors.New("field not present")
 
-func NewHeaders() Headers {
-	return Headers{}
+func NewHeaders() *Headers {
+	return &Headers{}
 }
 
-func (h Headers) SetContextType(conType ContentType) error {
-	v, ok := validContentTypes[conType]
-	if !ok {
-		return fmt.Errorf("invalid content type %d", conType)
+// SetContentType sets the Content-Type field to mediaType.
+func (h *Headers) SetContentType(mediaType MediaType) {
+	h.Set(contentTypeStr, mediaType.String())
+}
+
+// ContentType parses the Content-Type field.
+func (h *Headers) ContentType() (MediaType, error) {
+	value, err := h.Get(contentTypeStr)
+	if err != nil {
+		return MediaType{}, err
 	}
-	h[contentTypeStr] = v
-	return nil
+	return ParseMediaType(value)
+}
+
+// Add appends a value for fieldName, after any it already has.
+func (h *Headers) Add(fieldName, fieldValue string) {
+	h.fields = append(h.fields, field{name: fieldName, value: fieldValue})
+}
+
+// Set replaces every value of fieldName with fieldValue, keeping the place of
+// the first one.
+func (h *Headers) Set(fieldName, fieldValue string) {
+	for i := range h.fields {
+		if strings.EqualFold(h.fields[i].name, fieldName) {
+			h.fields[i] = field{name: fieldName, value: fieldValue}
+			h.del(fieldName, i+1)
+			return
+		}
+	}
+	h.Add(fieldName, fieldValue)
+}
+
+// Del removes every value of fieldName.
+func (h *Headers) Del(fieldName string) {
+	h.del(fieldName, 0)
+}
+
+func (h *Headers) del(fieldName string, from int) {
+	h.fields = append(h.fields[:from], slices.DeleteFunc(h.fields[from:], func(f field) bool {
+		return strings.EqualFold(f.name, fieldName)
+	})...)
+}
+
+// Values returns every value of fieldName in the order they were added.
+func (h *Headers) Values(fieldName string) []string {
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, fieldName) {
+			values = append(values, f.value)
+		}
+	}
+	return values
 }
 
-func (h Headers) AddHeader(fieldName, fieldValue string) {
-	h[fieldName] = fieldValue
+func (h *Headers) Has(fieldName string) bool {
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, fieldName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Clone returns a copy of h that can be changed independently.
+func (h *Headers) Clone() *Headers {
+	return &Headers{fields: slices.Clone(h.fields)}
+}
+
+// Len returns the number of fields, counting each value of a repeated field.
+func (h *Headers) Len() int {
+	return len(h.fields)
+}
+
+// All yields every field name and value in order.
+func (h *Headers) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		for _, f := range h.fields {
+			if !yield(f.name, f.value) {
+				return
+			}
+		}
+	}
 }
 
-func (h Headers) AddTrailers(trailerNames []string) {
+func (h *Headers) AddTrailers(trailerNames []string) {
 	if len(trailerNames) == 0 {
 		return
 	}
-	value := trailerNames[0]
-	for i := 1; i < len(trailerNames); i++ {
-		value += ", " + trailerNames[i]
+	h.Set(trailerFieldName, strings.Join(trailerNames, ", "))
+}
+
+// TrailerNames returns the field names declared in Trailer.
+func (h *Headers) TrailerNames() []string {
+	var names []string
+	for _, value := range h.Values(trailerFieldName) {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
 	}
-	h[trailerFieldName] = value
+	return names
+}
+
+// ForbiddenTrailer reports whether fieldName must not be sent as a trailer,
+// because framing, routing, authentication or content handling depend on it.
+func ForbiddenTrailer(fieldName string) bool {
+	_, forbidden := forbiddenTrailers[strings.ToLower(fieldName)]
+	return forbidden
 }
 
-func (h Headers) Get(fieldName string) (string, error) {
-	value, ok := h[strings.ToLower(fieldName)]
-	if !ok {
-		return "", fmt.Errorf("field name %s not present", fieldName)
+var forbiddenTrailers = map[string]struct{}{
+	"authorization":       {},
+	"cache-control":       {},
+	"connection":          {},
+	"content-encoding":    {},
+	"content-length":      {},
+	"content-range":       {},
+	"content-type":        {},
+	"expect":              {},
+	"host":                {},
+	"keep-alive":          {},
+	"max-forwards":        {},
+	"pragma":              {},
+	"proxy-authenticate":  {},
+	"proxy-authorization": {},
+	"proxy-connection":    {},
+	"range":               {},
+	"set-cookie":          {},
+	"te":                  {},
+	"trailer":             {},
+	"transfer-encoding":   {},
+	"www-authenticate":    {},
+}
+
+// Get returns the value of fieldName, with repeated values joined by ", ".
+func (h *Headers) Get(fieldName string) (string, error) {
+	values := h.Values(fieldName)
+	if len(values) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrFieldNotPresent, fieldName)
 	}
-	return value, nil
+	return strings.Join(values, ", "), nil
 }
 
-func (h Headers) Parse(data []byte) (n int, done bool, err error) {
-	index := bytes.Index(data, lineEndBytes)
-	//fmt.Printf("index: %d, %s\n", index, string(data[:index]))
+// HasToken reports whether the comma-separated value of fieldName contains
+// token, compared case-insensitively.
+func (h *Headers) HasToken(fieldName, token string) bool {
+	for _, value := range h.Values(fieldName) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseMode is how strictly a message head is read.
+type ParseMode int
+
+const (
+	// ParseStrict follows RFC 9112 to the letter: lines end in CRLF, field
+	// names are ASCII tokens directly followed by the colon, values hold no
+	// control characters other than HTAB, and obs-fold is rejected.
+	ParseStrict ParseMode = 0
+	// ParseLenient also takes a bare LF as a line end and unfolds obs-fold
+	// into a single space, as RFC 9112 allows a recipient to do. Values only
+	// have to be free of NUL.
+	ParseLenient ParseMode = 1
+)
+
+// CutLine returns the next line in data without its line end, and n, the
+// number of bytes up to and including the line end. n is 0 while data does
+// not hold a whole line. A CR anywhere but before the LF is an error in
+// either mode.
+func (m ParseMode) CutLine(data []byte) (line []byte, n int, err error) {
+	index := bytes.IndexByte(data, '\n')
 	if index < 0 {
-		return 0, false, nil
-	} else if index == 0 {
-		return lineEndLen, true, nil
-	} else {
-		key, value, found := strings.Cut(string(data[:index]), sep)
-		//fmt.Println(key, value)
-		if !found {
-			return 0, false, fmt.Errorf("no field-name:field-value pair found")
+		return nil, 0, nil
+	}
+	line = data[:index]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	} else if m != ParseLenient {
+		return nil, 0, fmt.Errorf("bare LF line end")
+	}
+	if bytes.IndexByte(line, '\r') >= 0 {
+		return nil, 0, fmt.Errorf("bare CR in line")
+	}
+	return line, index + 1, nil
+}
+
+// Parse reads one field line from data with ParseStrict. See ParseWith.
+func (h *Headers) Parse(data []byte) (n int, done bool, err error) {
+	return h.ParseWith(data, ParseStrict)
+}
+
+// ParseWith reads one field line from data, or the empty line that ends the
+// section, in which case done is true. n is 0 while data does not hold a
+// whole line.
+func (h *Headers) ParseWith(data []byte, mode ParseMode) (n int, done bool, err error) {
+	line, n, err := mode.CutLine(data)
+	if err != nil || n == 0 {
+		return 0, false, err
+	}
+	if len(line) == 0 {
+		return n, true, nil
+	}
+	if line[0] == ' ' || line[0] == '\t' {
+		// obs-fold, continuing the value of the field above
+		if mode != ParseLenient || len(h.fields) == 0 {
+			return 0, false, fmt.Errorf("folded field line '%s'", line)
 		}
-		keyTrim := strings.TrimRightFunc(key, func(r rune) bool { return unicode.IsSpace(r) })
-		if keyTrim != key {
-			return 0, false, fmt.Errorf("whitespace after field name '%s'", key)
+		value := strings.Trim(string(line), optionalWhitespace)
+		last := &h.fields[len(h.fields)-1]
+		if !mode.validValue(value) {
+			return 0, false, fmt.Errorf("invalid character in value of field %s", last.name)
 		}
-		key = strings.TrimLeftFunc(key, func(r rune) bool { return unicode.IsSpace(r) })
-		if !validFieldName(key) {
-			return 0, false, fmt.Errorf("invalid character in field name '%s'", key)
+		if last.value == "" {
+			last.value = value
+		} else if value != "" {
+			last.value += " " + value
 		}
-		key = strings.ToLower(key)
-		value = strings.TrimFunc(value, func(r rune) bool { return unicode.IsSpace(r) })
-		existing, there := h[key]
-		if there {
-			h[key] = existing + ", " + value
-		} else {
-			h[key] = value
+		return n, false, nil
+	}
+	name, value, found := strings.Cut(string(line), sep)
+	if !found {
+		return 0, false, fmt.Errorf("no field-name:field-value pair found")
+	}
+	if strings.TrimRight(name, optionalWhitespace) != name {
+		return 0, false, fmt.Errorf("whitespace after field name '%s'", name)
+	}
+	if !IsToken(name) {
+		return 0, false, fmt.Errorf("invalid character in field name '%s'", name)
+	}
+	value = strings.Trim(value, optionalWhitespace)
+	if !mode.validValue(value) {
+		return 0, false, fmt.Errorf("invalid character in value of field %s", name)
+	}
+	h.Add(name, value)
+	return n, false, nil
+}
+
+// validValue reports whether a field value holds only characters mode
+// allows: no control characters but HTAB when strict, no NUL when lenient.
+func (m ParseMode) validValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == 0 || m != ParseLenient && (c < ' ' && c != '\t' || c == 0x7f) {
+			return false
 		}
-		return index + lineEndLen, false, nil
 	}
+	return true
 }
 
-func validFieldName(fieldName string) bool {
-	if len(fieldName) == 0 {
+// IsToken reports whether s is a token, the syntax of methods, field names,
+// media types and their parameters: one or more ASCII letters, digits or
+// tchar symbols.
+func IsToken(s string) bool {
+	if len(s) == 0 {
 		return false
 	}
-	for _, r := range fieldName {
-		if unicode.IsLetter(r) || unicode.IsDigit(r) {
-			continue
-		}
-		if _, valid := validRuneSet[r]; !valid {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte(tcharSymbols, c) >= 0) {
 			return false
 		}
 	}
 	return true
 }
 
-const (
-	validRunes     = "!#$%&'*+-.^_`|~"
-	contentTypeStr = "Content-Type"
-	textPlainStr   = "text/plain"
-	textHTMLStr    = "text/html"
-	videoStr       = "video/mp4"
-)
-
-var validRuneSet map[rune]struct{}
-var validContentTypes map[ContentType]string
+// CanonicalName returns the MIME-canonical form of a field name, with the
+// first letter and each letter after a hyphen upper case and the rest lower
+// case, so content-length becomes Content-Length. Names that are not valid
+// tokens are returned unchanged.
+func CanonicalName(fieldName string) string {
+	if !IsToken(fieldName) {
+		return fieldName
+	}
+	name := []byte(fieldName)
+	upper := true
+	for i, c := range name {
+		if upper && 'a' <= c && c <= 'z' {
+			name[i] = c - 'a' + 'A'
+		} else if !upper && 'A' <= c && c <= 'Z' {
+			name[i] = c - 'A' + 'a'
+		}
+		upper = c == '-'
+	}
+	return string(name)
+}
 
-func init() {
-	validRuneSet = make(map[rune]struct{})
-	for _, r := range validRunes {
-		validRuneSet[r] = struct{}{}
+// ValidateField checks that a field can be written out as is: the name must
+// be a token and the value must not contain CR, LF or NUL, any of which would
+// let it break out of its line.
+func ValidateField(fieldName, fieldValue string) error {
+	if !IsToken(fieldName) {
+		return fmt.Errorf("invalid field name '%s'", fieldName)
+	}
+	if strings.ContainsAny(fieldValue, "\r\n\x00") {
+		return fmt.Errorf("invalid character in value of field %s", fieldName)
 	}
-	validContentTypes = make(map[ContentType]string)
-	validContentTypes[ContentTypeTextPlain] = textPlainStr
-	validContentTypes[ContentTypeTextHTML] = textHTMLStr
-	validContentTypes[ContentTypeVideo] = videoStr
+	return nil
 }
+
+const (
+	tcharSymbols       = "!#$%&'*+-.^_`|~"
+	optionalWhitespace = " \t"
+	contentTypeStr     = "Content-Type"
+)
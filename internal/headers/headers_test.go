@@ -2,6 +2,7 @@ package headers
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -15,26 +16,26 @@ func TestHeadersParse(t *testing.T) {
 	n, done, err := headers.Parse(data)
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 
 	// Test: Valid double header with done
 	headers = NewHeaders()
-	data = []byte(" Host: localhost:42069  \r\n  gUest: freakonaleash69\r\n\r\n")
+	data = []byte("Host: localhost:42069  \r\ngUest: freakonaleash69\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	total := n
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, 26, n)
+	assert.Equal(t, 25, n)
 	assert.False(t, done)
 	n, done, err = headers.Parse(data[total:])
 	total += n
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, "localhost:42069", headers["host"])
-	assert.Equal(t, "freakonaleash69", headers["guest"])
-	assert.Equal(t, 26, n)
+	assert.Equal(t, []string{"localhost:42069"}, headers.Values("host"))
+	assert.Equal(t, []string{"freakonaleash69"}, headers.Values("guest"))
+	assert.Equal(t, 24, n)
 	assert.False(t, done)
 	n, done, err = headers.Parse(data[total:])
 	assert.Equal(t, 2, n)
@@ -67,27 +68,216 @@ func TestHeadersParse(t *testing.T) {
 
 	// Test: Valid double header with existing headers with done
 	headers = NewHeaders()
-	headers["billy"] = "bob"
-	headers["frank"] = "joke"
-	data = []byte(" Billy: Briggs  \r\n  gUest: freakonaleash69\r\n\r\n")
+	headers.Add("billy", "bob")
+	headers.Add("frank", "joke")
+	data = []byte("Billy: Briggs  \r\ngUest: freakonaleash69\r\n\r\n")
 	n, done, err = headers.Parse(data)
 	total = n
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, 18, n)
+	assert.Equal(t, 17, n)
 	assert.False(t, done)
 	n, done, err = headers.Parse(data[total:])
 	total += n
 	require.NoError(t, err)
 	require.NotNil(t, headers)
-	assert.Equal(t, 26, n)
+	assert.Equal(t, 24, n)
 	assert.False(t, done)
-	assert.Equal(t, "bob, Briggs", headers["billy"])
-	assert.Equal(t, "joke", headers["frank"])
-	assert.Equal(t, "freakonaleash69", headers["guest"])
+	assert.Equal(t, []string{"bob", "Briggs"}, headers.Values("billy"))
+	assert.Equal(t, []string{"joke"}, headers.Values("frank"))
+	assert.Equal(t, []string{"freakonaleash69"}, headers.Values("guest"))
 	n, done, err = headers.Parse(data[total:])
 	assert.Equal(t, 2, n)
 	assert.True(t, done)
 	require.NoError(t, err)
 
 }
+
+func TestParseModes(t *testing.T) {
+	parseAll := func(data string, mode ParseMode) (*Headers, error) {
+		headers := NewHeaders()
+		for data != "" {
+			n, done, err := headers.ParseWith([]byte(data), mode)
+			if err != nil {
+				return nil, err
+			}
+			if done || n == 0 {
+				break
+			}
+			data = data[n:]
+		}
+		return headers, nil
+	}
+
+	// Test: Strict mode rejects what RFC 9112 does not allow
+	for _, data := range []string{
+		"Host: localhost\n\n",
+		"Host: localhost\r\nX-Folded: a\r\n b\r\n\r\n",
+		" Host: localhost\r\n\r\n",
+		"Hóst: localhost\r\n\r\n",
+		"Host\t: localhost\r\n\r\n",
+		"X-Ctl: a\x01b\r\n\r\n",
+		"X-Cr: a\rb\r\n\r\n",
+	} {
+		_, err := parseAll(data, ParseStrict)
+		assert.Error(t, err, "%q", data)
+	}
+
+	// Test: Lenient mode takes bare LF and unfolds obs-fold
+	headers, err := parseAll("Host: localhost\nX-Folded: a\r\n  b\r\n\tc \n\n", ParseLenient)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"localhost"}, headers.Values("Host"))
+	assert.Equal(t, []string{"a b c"}, headers.Values("X-Folded"))
+	assert.Equal(t, 2, headers.Len())
+	headers, err = parseAll("X-Ctl: a\x01b\r\n\r\n", ParseLenient)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a\x01b"}, headers.Values("X-Ctl"))
+
+	// Test: Lenient mode still rejects what it cannot read unambiguously
+	for _, data := range []string{
+		" Host: localhost\r\n\r\n",
+		"Hóst: localhost\r\n\r\n",
+		"Host : localhost\r\n\r\n",
+		"X-Cr: a\rb\r\n\r\n",
+		"X-Nul: a\x00b\r\n\r\n",
+	} {
+		_, err := parseAll(data, ParseLenient)
+		assert.Error(t, err, "%q", data)
+	}
+}
+
+func TestHeadersValues(t *testing.T) {
+	// Test: Repeated fields kept separately and in order
+	headers := NewHeaders()
+	data := []byte("Set-Cookie: a=1; Path=/\r\nHost: localhost\r\nset-cookie: b=2, c=3\r\n\r\n")
+	total := 0
+	for {
+		n, done, err := headers.Parse(data[total:])
+		require.NoError(t, err)
+		total += n
+		if done {
+			break
+		}
+	}
+	assert.Equal(t, []string{"a=1; Path=/", "b=2, c=3"}, headers.Values("SET-COOKIE"))
+	assert.Equal(t, 3, headers.Len())
+	var names []string
+	for name := range headers.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"Set-Cookie", "Host", "set-cookie"}, names)
+
+	// Test: Get joins repeated values
+	value, err := headers.Get("set-cookie")
+	require.NoError(t, err)
+	assert.Equal(t, "a=1; Path=/, b=2, c=3", value)
+	_, err = headers.Get("missing")
+	require.Error(t, err)
+
+	// Test: Set replaces every value in place of the first
+	headers.Set("SET-COOKIE", "d=4")
+	assert.Equal(t, []string{"d=4"}, headers.Values("Set-Cookie"))
+	names = nil
+	for name := range headers.All() {
+		names = append(names, name)
+	}
+	assert.Equal(t, []string{"SET-COOKIE", "Host"}, names)
+
+	// Test: Fields set by a handler can be looked up in any case
+	headers.Set("X-Custom-Field", "yes")
+	assert.True(t, headers.Has("x-custom-field"))
+	value, err = headers.Get("x-CUSTOM-field")
+	require.NoError(t, err)
+	assert.Equal(t, "yes", value)
+
+	// Test: Del removes every value
+	headers.Add("x-custom-field", "again")
+	headers.Del("X-CUSTOM-FIELD")
+	assert.False(t, headers.Has("X-Custom-Field"))
+	assert.Nil(t, headers.Values("X-Custom-Field"))
+	assert.Equal(t, 2, headers.Len())
+}
+
+func TestCanonicalName(t *testing.T) {
+	assert.Equal(t, "Content-Length", CanonicalName("content-length"))
+	assert.Equal(t, "X-Request-Id", CanonicalName("X-REQUEST-ID"))
+	assert.Equal(t, "Www-Authenticate", CanonicalName("WWW-Authenticate"))
+	assert.Equal(t, "bad name", CanonicalName("bad name"))
+
+	require.NoError(t, ValidateField("Location", "/next"))
+	require.Error(t, ValidateField("Location", "/next\r\nSet-Cookie: x=1"))
+	require.Error(t, ValidateField("Location", "/next\n"))
+	require.Error(t, ValidateField("Bad Name", "value"))
+}
+
+func TestQualityList(t *testing.T) {
+	// Test: Weights, defaults and parameters kept with the value
+	list, err := ParseQualityList(`text/html, text/*;q=0.3, text/plain;format="a,b";Q=0.7;ext=1, */*;q=0`)
+	require.NoError(t, err)
+	assert.Equal(t, []Weighted{
+		{Value: "text/html", Q: 1},
+		{Value: "text/*", Q: 0.3},
+		{Value: `text/plain;format="a,b"`, Q: 0.7},
+		{Value: "*/*", Q: 0},
+	}, list)
+
+	// Test: Empty elements skipped
+	list, err = ParseQualityList(" , gzip;q=1.000,, ")
+	require.NoError(t, err)
+	assert.Equal(t, []Weighted{{Value: "gzip", Q: 1}}, list)
+
+	// Test: Invalid weights
+	for _, value := range []string{"gzip;q=1.5", "gzip;q=0.1234", "gzip;q=abc", "gzip;q=", ";q=1"} {
+		_, err = ParseQualityList(value)
+		assert.Error(t, err, value)
+	}
+
+	// Test: Repeated fields read as one list
+	h := NewHeaders()
+	h.Add("Accept-Language", "fr")
+	h.Add("accept-language", "en;q=0.5")
+	list, err = h.QualityList("Accept-Language")
+	require.NoError(t, err)
+	assert.Equal(t, []Weighted{{Value: "fr", Q: 1}, {Value: "en", Q: 0.5}}, list)
+}
+
+func TestCookies(t *testing.T) {
+	// Test: Cookie field parsed, malformed pairs skipped
+	h := NewHeaders()
+	h.Add("Cookie", `session=abc123; theme="dark"; bad pair; =x; lang=en`)
+	h.Add("cookie", "extra=1")
+	assert.Equal(t, []Cookie{
+		{Name: "session", Value: "abc123"},
+		{Name: "theme", Value: "dark"},
+		{Name: "lang", Value: "en"},
+		{Name: "extra", Value: "1"},
+	}, h.Cookies())
+
+	// Test: Every attribute serialized
+	expires := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	c := Cookie{
+		Name: "id", Value: "a3fWa", Expires: expires, MaxAge: 3600, Domain: "example.com", Path: "/docs",
+		Secure: true, HttpOnly: true, SameSite: SameSiteNone, Partitioned: true,
+	}
+	assert.Equal(t, "id=a3fWa; Expires=Wed, 02 Jan 2030 02:04:05 GMT; Max-Age=3600; Domain=example.com; Path=/docs; Secure; HttpOnly; SameSite=None; Partitioned", c.String())
+	assert.Equal(t, "gone=; Max-Age=0", Cookie{Name: "gone", MaxAge: -1}.String())
+
+	// Test: Several cookies set in one response
+	h = NewHeaders()
+	require.NoError(t, h.AddCookie(Cookie{Name: "a", Value: "1", SameSite: SameSiteLax}))
+	require.NoError(t, h.AddCookie(Cookie{Name: "b", Value: "2", HttpOnly: true}))
+	assert.Equal(t, []string{"a=1; SameSite=Lax", "b=2; HttpOnly"}, h.Values("Set-Cookie"))
+
+	// Test: Invalid cookies rejected
+	for _, c := range []Cookie{
+		{Name: "bad name", Value: "1"},
+		{Name: "a", Value: "1;2"},
+		{Name: "a", Value: "1", Path: "/\r\nX-Injected: 1"},
+		{Name: "a", Value: "1", SameSite: SameSiteNone},
+		{Name: "a", Value: "1", Partitioned: true},
+		{Name: "a", Value: "1", SameSite: 7},
+	} {
+		assert.Error(t, h.AddCookie(c), c.String())
+	}
+	assert.Equal(t, 2, h.Len())
+}
@@ -0,0 +1,193 @@
+package headers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MediaType is a Content-Type value: a type, a subtype and any parameters,
+// such as text/html; charset=utf-8. Type, subtype and parameter names are
+// kept in lower case; parameters keep their order.
+type MediaType struct {
+	Type    string
+	Subtype string
+	Params  []MediaParam
+}
+
+type MediaParam struct {
+	Name  string
+	Value string
+}
+
+var (
+	TextPlain       = MediaType{Type: "text", Subtype: "plain", Params: []MediaParam{{"charset", "utf-8"}}}
+	TextHTML        = MediaType{Type: "text", Subtype: "html", Params: []MediaParam{{"charset", "utf-8"}}}
+	ApplicationJSON = MediaType{Type: "application", Subtype: "json"}
+	OctetStream     = MediaType{Type: "application", Subtype: "octet-stream"}
+	VideoMP4        = MediaType{Type: "video", Subtype: "mp4"}
+)
+
+// NewMediaType returns the media type typ/subtype with no parameters.
+func NewMediaType(typ, subtype string) MediaType {
+	return MediaType{Type: strings.ToLower(typ), Subtype: strings.ToLower(subtype)}
+}
+
+// ParseMediaType parses a Content-Type value. Parameter values may be tokens
+// or quoted strings.
+func ParseMediaType(value string) (MediaType, error) {
+	essence, rest, _ := strings.Cut(value, ";")
+	typ, subtype, found := strings.Cut(strings.TrimSpace(essence), "/")
+	if !found || !IsToken(typ) || !IsToken(subtype) {
+		return MediaType{}, fmt.Errorf("invalid media type '%s'", value)
+	}
+	m := NewMediaType(typ, subtype)
+	for {
+		rest = strings.TrimLeft(rest, " \t")
+		if rest == "" {
+			return m, nil
+		}
+		name, after, found := strings.Cut(rest, "=")
+		name = strings.TrimSpace(name)
+		if !found || !IsToken(name) {
+			return MediaType{}, fmt.Errorf("invalid parameter in media type '%s'", value)
+		}
+		paramValue, after, err := cutParamValue(after)
+		if err != nil {
+			return MediaType{}, fmt.Errorf("%w in media type '%s'", err, value)
+		}
+		m.Params = append(m.Params, MediaParam{Name: strings.ToLower(name), Value: paramValue})
+		after = strings.TrimLeft(after, " \t")
+		if after != "" && after[0] != ';' {
+			return MediaType{}, fmt.Errorf("invalid parameter in media type '%s'", value)
+		}
+		rest = strings.TrimPrefix(after, ";")
+	}
+}
+
+// cutParamValue reads a token or quoted-string parameter value off the front
+// of s and returns it unquoted, along with what follows it.
+func cutParamValue(s string) (value, rest string, err error) {
+	if !strings.HasPrefix(s, `"`) {
+		end := strings.IndexAny(s, "; \t")
+		if end < 0 {
+			end = len(s)
+		}
+		if !IsToken(s[:end]) {
+			return "", "", fmt.Errorf("invalid parameter value '%s'", s[:end])
+		}
+		return s[:end], s[end:], nil
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), s[i+1:], nil
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", "", fmt.Errorf("unterminated quoted string")
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return "", "", fmt.Errorf("unterminated quoted string")
+}
+
+// Param returns the value of the named parameter, or "" if it is not set.
+func (m MediaType) Param(name string) string {
+	for _, p := range m.Params {
+		if strings.EqualFold(p.Name, name) {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// WithParam returns a copy of m with the named parameter set to value,
+// replacing any value it had.
+func (m MediaType) WithParam(name, value string) MediaType {
+	params := make([]MediaParam, 0, len(m.Params)+1)
+	for _, p := range m.Params {
+		if !strings.EqualFold(p.Name, name) {
+			params = append(params, p)
+		}
+	}
+	m.Params = append(params, MediaParam{Name: strings.ToLower(name), Value: value})
+	return m
+}
+
+// Essence returns type/subtype without parameters.
+func (m MediaType) Essence() string {
+	return m.Type + "/" + m.Subtype
+}
+
+// String formats m for a Content-Type field, quoting parameter values that
+// are not tokens.
+func (m MediaType) String() string {
+	var b strings.Builder
+	b.WriteString(m.Essence())
+	for _, p := range m.Params {
+		b.WriteString("; ")
+		b.WriteString(p.Name)
+		b.WriteByte('=')
+		if IsToken(p.Value) {
+			b.WriteString(p.Value)
+			continue
+		}
+		b.WriteByte('"')
+		for _, c := range []byte(p.Value) {
+			if c == '"' || c == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+// MediaTypeByExtension looks up the media type for a file extension such as
+// ".png" or "json" in the built-in registry.
+func MediaTypeByExtension(ext string) (MediaType, bool) {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+	m, ok := mediaTypesByExtension[ext]
+	return m, ok
+}
+
+var mediaTypesByExtension = map[string]MediaType{
+	".html":  TextHTML,
+	".htm":   TextHTML,
+	".txt":   TextPlain,
+	".css":   NewMediaType("text", "css").WithParam("charset", "utf-8"),
+	".csv":   NewMediaType("text", "csv").WithParam("charset", "utf-8"),
+	".js":    NewMediaType("text", "javascript").WithParam("charset", "utf-8"),
+	".mjs":   NewMediaType("text", "javascript").WithParam("charset", "utf-8"),
+	".md":    NewMediaType("text", "markdown").WithParam("charset", "utf-8"),
+	".json":  ApplicationJSON,
+	".xml":   NewMediaType("application", "xml"),
+	".pdf":   NewMediaType("application", "pdf"),
+	".zip":   NewMediaType("application", "zip"),
+	".gz":    NewMediaType("application", "gzip"),
+	".tar":   NewMediaType("application", "x-tar"),
+	".wasm":  NewMediaType("application", "wasm"),
+	".bin":   OctetStream,
+	".png":   NewMediaType("image", "png"),
+	".jpg":   NewMediaType("image", "jpeg"),
+	".jpeg":  NewMediaType("image", "jpeg"),
+	".gif":   NewMediaType("image", "gif"),
+	".webp":  NewMediaType("image", "webp"),
+	".avif":  NewMediaType("image", "avif"),
+	".svg":   NewMediaType("image", "svg+xml"),
+	".ico":   NewMediaType("image", "vnd.microsoft.icon"),
+	".mp4":   VideoMP4,
+	".webm":  NewMediaType("video", "webm"),
+	".mp3":   NewMediaType("audio", "mpeg"),
+	".ogg":   NewMediaType("audio", "ogg"),
+	".wav":   NewMediaType("audio", "wav"),
+	".woff":  NewMediaType("font", "woff"),
+	".woff2": NewMediaType("font", "woff2"),
+	".ttf":   NewMediaType("font", "ttf"),
+}
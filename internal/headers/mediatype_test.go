@@ -0,0 +1,63 @@
+package headers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMediaType(t *testing.T) {
+	// Test: Type, subtype and parameters in lower case, values kept as sent
+	m, err := ParseMediaType("Text/HTML; Charset=UTF-8")
+	require.NoError(t, err)
+	assert.Equal(t, "text/html", m.Essence())
+	assert.Equal(t, "UTF-8", m.Param("charset"))
+	assert.Equal(t, "text/html; charset=UTF-8", m.String())
+
+	// Test: Quoted parameter values
+	m, err = ParseMediaType(`multipart/form-data; boundary="a b;c"; x="q\"d"`)
+	require.NoError(t, err)
+	assert.Equal(t, "a b;c", m.Param("boundary"))
+	assert.Equal(t, `q"d`, m.Param("x"))
+	assert.Equal(t, `multipart/form-data; boundary="a b;c"; x="q\"d"`, m.String())
+
+	// Test: No parameters
+	m, err = ParseMediaType("application/json")
+	require.NoError(t, err)
+	assert.Equal(t, ApplicationJSON, m)
+
+	// Test: Invalid media types
+	for _, value := range []string{"", "text", "text/", "/html", "text/html; charset", "text/html; charset=\"utf-8", "text/html; a=b c"} {
+		_, err = ParseMediaType(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestMediaTypeHeaders(t *testing.T) {
+	// Test: Any media type can be set and read back
+	h := NewHeaders()
+	h.SetContentType(NewMediaType("Image", "PNG"))
+	value, err := h.Get("content-type")
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", value)
+	h.SetContentType(ApplicationJSON.WithParam("charset", "utf-8"))
+	m, err := h.ContentType()
+	require.NoError(t, err)
+	assert.Equal(t, "application/json; charset=utf-8", m.String())
+
+	// Test: WithParam replaces without touching the original
+	latin := TextPlain.WithParam("Charset", "iso-8859-1")
+	assert.Equal(t, "text/plain; charset=iso-8859-1", latin.String())
+	assert.Equal(t, "text/plain; charset=utf-8", TextPlain.String())
+
+	// Test: Lookup by extension
+	m, ok := MediaTypeByExtension(".PNG")
+	require.True(t, ok)
+	assert.Equal(t, "image/png", m.String())
+	m, ok = MediaTypeByExtension("json")
+	require.True(t, ok)
+	assert.Equal(t, ApplicationJSON, m)
+	_, ok = MediaTypeByExtension(".nope")
+	assert.False(t, ok)
+}
@@ -0,0 +1,94 @@
+package headers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Weighted is one element of a quality-valued list such as Accept: the value
+// with any parameters other than q, and its weight between 0 and 1.
+type Weighted struct {
+	Value string
+	Q     float64
+}
+
+// ParseQualityList parses a comma-separated list whose elements may carry a
+// q weight, like "text/html;q=0.9, */*;q=0.1". Elements without one weigh 1.
+// Elements are returned in the order they were sent.
+func ParseQualityList(value string) ([]Weighted, error) {
+	var list []Weighted
+	for _, element := range splitQuoted(value, ',') {
+		element = strings.TrimSpace(element)
+		if element == "" {
+			continue
+		}
+		parts := splitQuoted(element, ';')
+		w := Weighted{Value: strings.TrimSpace(parts[0]), Q: 1}
+		if w.Value == "" {
+			return nil, fmt.Errorf("empty element in list '%s'", value)
+		}
+		for i, param := range parts[1:] {
+			name, weight, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || !strings.EqualFold(strings.TrimSpace(name), "q") {
+				continue
+			}
+			q, err := parseQ(strings.TrimSpace(weight))
+			if err != nil {
+				return nil, err
+			}
+			w.Q = q
+			// anything after q is an extension, not part of the value
+			parts = parts[:i+1]
+			break
+		}
+		for _, param := range parts[1:] {
+			w.Value += ";" + strings.TrimSpace(param)
+		}
+		list = append(list, w)
+	}
+	return list, nil
+}
+
+// QualityList parses the quality-valued list in every value of fieldName.
+func (h *Headers) QualityList(fieldName string) ([]Weighted, error) {
+	value, err := h.Get(fieldName)
+	if err != nil {
+		return nil, err
+	}
+	return ParseQualityList(value)
+}
+
+// parseQ parses a weight: 0 or 1 with at most three decimals.
+func parseQ(s string) (float64, error) {
+	whole, decimals, _ := strings.Cut(s, ".")
+	valid := (whole == "0" || whole == "1") && len(decimals) <= 3
+	for _, c := range decimals {
+		if c < '0' || c > '9' || (whole == "1" && c != '0') {
+			valid = false
+		}
+	}
+	if !valid {
+		return 0, fmt.Errorf("invalid weight q=%s", s)
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// splitQuoted splits s at every sep outside a quoted string.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
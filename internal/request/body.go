@@ -0,0 +1,122 @@
+package request
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrBodyReadAfterClose = errors.New("read on closed request body")
+
+// body streams a request body off the connection as the handler reads it,
+// stripping the chunked framing when there is one.
+type body struct {
+	rr        *Reader
+	req       *Request
+	remaining int64
+	chunked   *chunkedDecoder
+	closed    bool
+	err       error
+}
+
+func newBody(rr *Reader, req *Request) *body {
+	return &body{rr: rr, req: req, remaining: req.bodyLength, chunked: req.chunked}
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrBodyReadAfterClose
+	}
+	return b.read(p)
+}
+
+// Close stops the handler from reading further. The unread part of the body
+// stays on the connection until the next request is read.
+func (b *body) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (b *body) read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.req.state == requestStateDone {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	var n int
+	var err error
+	if b.chunked != nil {
+		n, err = b.readChunked(p)
+	} else {
+		n, err = b.readLength(p)
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		b.err = err
+		b.req.bodyErr = err
+	} else if n == 0 && b.req.state == requestStateDone {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (b *body) readLength(p []byte) (int, error) {
+	p = p[:min(int64(len(p)), b.remaining)]
+	var n int
+	if buffered := b.rr.buffered(); len(buffered) > 0 {
+		n = copy(p, buffered)
+		b.rr.consume(n)
+	} else {
+		// nothing buffered, so read straight into the caller's slice
+		var err error
+		n, err = b.rr.src.Read(p)
+		if n == 0 && err != nil {
+			return 0, err
+		}
+	}
+	b.remaining -= int64(n)
+	if b.remaining == 0 {
+		b.req.state = requestStateDone
+	}
+	return n, nil
+}
+
+func (b *body) readChunked(p []byte) (int, error) {
+	for {
+		consumed, payload, err := b.chunked.decode(b.rr.buffered(), len(p))
+		if err != nil {
+			return 0, err
+		}
+		n := copy(p, payload)
+		b.rr.consume(consumed)
+		if b.chunked.done() {
+			b.req.state = requestStateDone
+		}
+		if n > 0 || b.chunked.done() {
+			return n, nil
+		}
+		if consumed == 0 {
+			if err := b.rr.fill(); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// drain reads off whatever is left of the body so the next request can be
+// parsed.
+func (b *body) drain() error {
+	_, err := io.Copy(io.Discard, readerFunc(b.read))
+	return err
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
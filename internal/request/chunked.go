@@ -0,0 +1,210 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"strconv"
+	"strings"
+)
+
+type chunkState int
+
+const (
+	chunkStateSize     chunkState = 0
+	chunkStateData     chunkState = 1
+	chunkStateDataEnd  chunkState = 2
+	chunkStateTrailers chunkState = 3
+	chunkStateDone     chunkState = 4
+	chunkExtSep                   = ";"
+	maxChunkSizeDigits            = 15
+	maxChunkLineBytes             = 4096
+)
+
+var lineEndBytes = []byte(lineEnd)
+
+// chunkedDecoder strips the framing from a chunked body as it arrives,
+// collecting any trailer fields sent after the last chunk.
+type chunkedDecoder struct {
+	state        chunkState
+	remaining    int64
+	total        int64
+	trailers     *headers.Headers
+	trailerBytes int
+	trailerCount int
+	limits       Limits
+}
+
+func newChunkedDecoder(trailers *headers.Headers, limits Limits) *chunkedDecoder {
+	return &chunkedDecoder{state: chunkStateSize, trailers: trailers, limits: limits}
+}
+
+func (d *chunkedDecoder) done() bool {
+	return d.state == chunkStateDone
+}
+
+// decode consumes as much of data as it can and returns the number of bytes
+// consumed along with the chunk payload it found, a subslice of data. At most
+// one contiguous piece of payload, no longer than limit, is returned per call.
+func (d *chunkedDecoder) decode(data []byte, limit int) (int, []byte, error) {
+	consumed := 0
+	for d.state != chunkStateDone {
+		switch d.state {
+		case chunkStateSize:
+			index := bytes.IndexByte(data[consumed:], '\n')
+			if index < 0 {
+				if len(data)-consumed > maxChunkLineBytes {
+					return consumed, nil, fmt.Errorf("chunk size line longer than %d bytes", maxChunkLineBytes)
+				}
+				return consumed, nil, nil
+			}
+			if index == 0 || data[consumed+index-1] != '\r' {
+				// a proxy ending the line here would frame the body differently
+				return consumed, nil, fmt.Errorf("bare LF in chunk size line")
+			}
+			size, err := parseChunkSize(string(data[consumed : consumed+index-1]))
+			if err != nil {
+				return consumed, nil, err
+			}
+			consumed += index + 1
+			d.total += size
+			if err := d.limits.checkBody(d.total); err != nil {
+				return consumed, nil, err
+			}
+			if size == 0 {
+				d.state = chunkStateTrailers
+			} else {
+				d.remaining = size
+				d.state = chunkStateData
+			}
+		case chunkStateData:
+			if consumed == len(data) {
+				return consumed, nil, nil
+			}
+			n := int(min(int64(len(data)-consumed), int64(limit), d.remaining))
+			payload := data[consumed : consumed+n]
+			consumed += n
+			d.remaining -= int64(n)
+			if d.remaining == 0 {
+				d.state = chunkStateDataEnd
+			}
+			return consumed, payload, nil
+		case chunkStateDataEnd:
+			if len(data)-consumed < lineEndLen {
+				return consumed, nil, nil
+			}
+			if !bytes.HasPrefix(data[consumed:], lineEndBytes) {
+				return consumed, nil, fmt.Errorf("chunk data not followed by CRLF")
+			}
+			consumed += lineEndLen
+			d.state = chunkStateSize
+		case chunkStateTrailers:
+			n, done, err := d.trailers.Parse(data[consumed:])
+			if err != nil {
+				return consumed, nil, fmt.Errorf("invalid trailer: %w", err)
+			}
+			if n == 0 {
+				return consumed, nil, d.limits.checkHeaders(d.trailerBytes+len(data)-consumed, d.trailerCount)
+			}
+			consumed += n
+			d.trailerBytes += n
+			if !done {
+				d.trailerCount++
+			}
+			if err := d.limits.checkHeaders(d.trailerBytes, d.trailerCount); err != nil {
+				return consumed, nil, err
+			}
+			if done {
+				d.state = chunkStateDone
+			}
+		}
+	}
+	return consumed, nil, nil
+}
+
+// parseChunkSize reads the hex size from a chunk-size line. Chunk extensions
+// are checked and then ignored.
+func parseChunkSize(line string) (int64, error) {
+	end := strings.IndexAny(line, chunkExtSep+bws)
+	if end < 0 {
+		end = len(line)
+	}
+	sizeStr, ext := line[:end], line[end:]
+	if err := checkChunkExt(ext); err != nil {
+		return 0, err
+	}
+	if len(sizeStr) == 0 || len(sizeStr) > maxChunkSizeDigits {
+		return 0, fmt.Errorf("invalid chunk size '%s'", sizeStr)
+	}
+	for _, c := range sizeStr {
+		if !strings.ContainsRune(hexDigits, c) {
+			return 0, fmt.Errorf("invalid chunk size '%s'", sizeStr)
+		}
+	}
+	return strconv.ParseInt(sizeStr, 16, 64)
+}
+
+// checkChunkExt checks chunk extensions against
+// *( BWS ";" BWS token [ BWS "=" BWS ( token / quoted-string ) ] ), which
+// also rules out a CR anywhere in them.
+func checkChunkExt(ext string) error {
+	invalid := fmt.Errorf("invalid chunk extension '%s'", ext)
+	rest := strings.TrimLeft(ext, bws)
+	for rest != "" {
+		if rest[0] != ';' {
+			return invalid
+		}
+		rest = strings.TrimLeft(rest[1:], bws)
+		n := tokenLen(rest)
+		if n == 0 {
+			return invalid
+		}
+		rest = strings.TrimLeft(rest[n:], bws)
+		if strings.HasPrefix(rest, "=") {
+			rest = strings.TrimLeft(rest[1:], bws)
+			if strings.HasPrefix(rest, `"`) {
+				n = quotedStringLen(rest)
+			} else {
+				n = tokenLen(rest)
+			}
+			if n == 0 {
+				return invalid
+			}
+			rest = strings.TrimLeft(rest[n:], bws)
+		}
+	}
+	return nil
+}
+
+// tokenLen returns the length of the token s starts with.
+func tokenLen(s string) int {
+	n := 0
+	for n < len(s) && headers.IsToken(s[n:n+1]) {
+		n++
+	}
+	return n
+}
+
+// quotedStringLen returns the length of the quoted-string s starts with, or
+// 0 if it is not closed or holds a control character other than HTAB.
+func quotedStringLen(s string) int {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return i + 1
+		case c == '\\' && i+1 < len(s):
+			i++
+			c = s[i]
+		}
+		if c < ' ' && c != '\t' || c == 0x7f {
+			return 0
+		}
+	}
+	return 0
+}
+
+const (
+	hexDigits = "0123456789abcdefABCDEF"
+	bws       = " \t"
+)
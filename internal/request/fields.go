@@ -0,0 +1,129 @@
+package request
+
+import (
+	"fmt"
+	"httpfromtcp/internal/headers"
+	"strings"
+	"time"
+)
+
+const (
+	hostFieldName            = "host"
+	ifModifiedSinceFieldName = "if-modified-since"
+	ifNoneMatchFieldName     = "if-none-match"
+	rangeFieldName           = "range"
+	authorizationFieldName   = "authorization"
+)
+
+// The accessors below return an error wrapping headers.ErrFieldNotPresent
+// when the client did not send the field.
+
+// ContentLength returns the declared body length: -1 for a chunked body and 0
+// when neither Content-Length nor Transfer-Encoding was sent.
+func (r *Request) ContentLength() (int64, error) {
+	if r.Headers.Has(transferEncodingFieldName) {
+		return -1, nil
+	}
+	values := r.Headers.Values(contentLengthFieldName)
+	if len(values) == 0 {
+		return 0, nil
+	}
+	return parseContentLength(values)
+}
+
+// Host returns the host name and the port, "" if none was given, from the
+// Host field. An IPv6 address is returned without its brackets.
+func (r *Request) Host() (hostname, port string, err error) {
+	values := r.Headers.Values(hostFieldName)
+	switch {
+	case len(values) == 0:
+		return "", "", fmt.Errorf("%w: Host", headers.ErrFieldNotPresent)
+	case len(values) > 1:
+		return "", "", fmt.Errorf("more than one Host field")
+	}
+	return splitHost(values[0])
+}
+
+func splitHost(value string) (hostname, port string, err error) {
+	rest := value
+	if strings.HasPrefix(value, "[") {
+		end := strings.IndexByte(value, ']')
+		if end < 0 {
+			return "", "", fmt.Errorf("invalid Host '%s'", value)
+		}
+		hostname, rest = value[1:end], value[end+1:]
+		if rest != "" && rest[0] != ':' {
+			return "", "", fmt.Errorf("invalid Host '%s'", value)
+		}
+	} else {
+		var found bool
+		hostname, port, found = strings.Cut(value, ":")
+		rest = ""
+		if found {
+			rest = ":" + port
+		}
+	}
+	if hostname == "" || strings.ContainsAny(hostname, " \t/?#@") {
+		return "", "", fmt.Errorf("invalid Host '%s'", value)
+	}
+	port = strings.TrimPrefix(rest, ":")
+	for _, c := range port {
+		if c < '0' || c > '9' {
+			return "", "", fmt.Errorf("invalid port in Host '%s'", value)
+		}
+	}
+	return hostname, port, nil
+}
+
+// IfModifiedSince returns the date in If-Modified-Since.
+func (r *Request) IfModifiedSince() (time.Time, error) {
+	value, err := r.Headers.Get(ifModifiedSinceFieldName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return headers.ParseHTTPDate(value)
+}
+
+// IfNoneMatch returns the entity tags in If-None-Match.
+func (r *Request) IfNoneMatch() (headers.ETagList, error) {
+	value, err := r.Headers.Get(ifNoneMatchFieldName)
+	if err != nil {
+		return headers.ETagList{}, err
+	}
+	return headers.ParseETagList(value)
+}
+
+// Range returns the byte ranges asked for in Range.
+func (r *Request) Range() ([]headers.ByteRange, error) {
+	value, err := r.Headers.Get(rangeFieldName)
+	if err != nil {
+		return nil, err
+	}
+	return headers.ParseRange(value)
+}
+
+// Authorization returns the scheme and credentials in Authorization, such as
+// "Bearer" and the token.
+func (r *Request) Authorization() (scheme, credentials string, err error) {
+	value, err := r.Headers.Get(authorizationFieldName)
+	if err != nil {
+		return "", "", err
+	}
+	scheme, credentials, _ = strings.Cut(value, " ")
+	if !validScheme(scheme) {
+		return "", "", fmt.Errorf("invalid Authorization scheme '%s'", scheme)
+	}
+	return scheme, strings.TrimSpace(credentials), nil
+}
+
+func validScheme(scheme string) bool {
+	if scheme == "" {
+		return false
+	}
+	for _, c := range scheme {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
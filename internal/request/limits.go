@@ -0,0 +1,73 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Limits caps how much a client may send. A zero field takes its value from
+// DefaultLimits and a negative one means no limit.
+type Limits struct {
+	MaxRequestLineBytes int
+	MaxHeaderBytes      int
+	MaxHeaderCount      int
+	MaxBodyBytes        int64
+}
+
+var DefaultLimits = Limits{
+	MaxRequestLineBytes: 8 << 10,
+	MaxHeaderBytes:      64 << 10,
+	MaxHeaderCount:      100,
+	MaxBodyBytes:        10 << 20,
+}
+
+var (
+	ErrRequestLineTooLong = errors.New("request line too long")
+	ErrHeadersTooLarge    = errors.New("header fields too large")
+	ErrBodyTooLarge       = errors.New("body too large")
+)
+
+// withDefaults fills the zero fields of l from DefaultLimits.
+func (l Limits) withDefaults() Limits {
+	if l.MaxRequestLineBytes == 0 {
+		l.MaxRequestLineBytes = DefaultLimits.MaxRequestLineBytes
+	}
+	if l.MaxHeaderBytes == 0 {
+		l.MaxHeaderBytes = DefaultLimits.MaxHeaderBytes
+	}
+	if l.MaxHeaderCount == 0 {
+		l.MaxHeaderCount = DefaultLimits.MaxHeaderCount
+	}
+	if l.MaxBodyBytes == 0 {
+		l.MaxBodyBytes = DefaultLimits.MaxBodyBytes
+	}
+	return l
+}
+
+func exceeds[T int | int64](size, limit T) bool {
+	return limit > 0 && size > limit
+}
+
+func (l Limits) checkRequestLine(size int) error {
+	if exceeds(size, l.MaxRequestLineBytes) {
+		return fmt.Errorf("%w: more than %d bytes", ErrRequestLineTooLong, l.MaxRequestLineBytes)
+	}
+	return nil
+}
+
+func (l Limits) checkHeaders(size, count int) error {
+	if exceeds(size, l.MaxHeaderBytes) {
+		return fmt.Errorf("%w: more than %d bytes", ErrHeadersTooLarge, l.MaxHeaderBytes)
+	}
+	if exceeds(count, l.MaxHeaderCount) {
+		return fmt.Errorf("%w: more than %d fields", ErrHeadersTooLarge, l.MaxHeaderCount)
+	}
+	return nil
+}
+
+func (l Limits) checkBody(size int64) error {
+	if exceeds(size, l.MaxBodyBytes) {
+		return fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, l.MaxBodyBytes)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package request
+
+import (
+	"errors"
+	"httpfromtcp/internal/headers"
+	"slices"
+	"strings"
+)
+
+const (
+	acceptFieldName         = "accept"
+	acceptEncodingFieldName = "accept-encoding"
+	acceptLanguageFieldName = "accept-language"
+	identityCoding          = "identity"
+	anyValue                = "*"
+)
+
+// ErrNotAcceptable is returned by Negotiate when none of the offered
+// representations is acceptable to the client, which calls for a 406.
+var ErrNotAcceptable = errors.New("no acceptable representation")
+
+// Offer lists the representations a handler can produce, most preferred
+// first. An empty list leaves that dimension out of the negotiation.
+type Offer struct {
+	MediaTypes []headers.MediaType
+	Encodings  []string
+	Languages  []string
+}
+
+// Choice is the representation picked by Negotiate.
+type Choice struct {
+	MediaType headers.MediaType
+	Encoding  string
+	Language  string
+}
+
+// Negotiate picks the offered media type, content coding and language the
+// client weighs highest in Accept, Accept-Encoding and Accept-Language, with
+// ties going to the earlier offer. A missing, empty or malformed field
+// accepts anything, except that without Accept-Encoding identity is preferred
+// when offered, and an empty Accept-Encoding allows identity alone. It returns
+// ErrNotAcceptable when some dimension has no acceptable offer.
+func Negotiate(req *Request, offer Offer) (Choice, error) {
+	var choice Choice
+	if len(offer.MediaTypes) > 0 {
+		accept := qualities(req, acceptFieldName)
+		i := pick(len(offer.MediaTypes), func(i int) float64 {
+			return weigh(accept, func(rng string) int { return mediaRangeMatch(rng, offer.MediaTypes[i]) })
+		})
+		if i < 0 {
+			return Choice{}, ErrNotAcceptable
+		}
+		choice.MediaType = offer.MediaTypes[i]
+	}
+	if len(offer.Encodings) > 0 {
+		i := pickEncoding(req, offer.Encodings)
+		if i < 0 {
+			return Choice{}, ErrNotAcceptable
+		}
+		choice.Encoding = offer.Encodings[i]
+	}
+	if len(offer.Languages) > 0 {
+		accept := qualities(req, acceptLanguageFieldName)
+		i := pick(len(offer.Languages), func(i int) float64 {
+			return weigh(accept, func(rng string) int { return languageRangeMatch(rng, offer.Languages[i]) })
+		})
+		if i < 0 {
+			return Choice{}, ErrNotAcceptable
+		}
+		choice.Language = offer.Languages[i]
+	}
+	return choice, nil
+}
+
+func pickEncoding(req *Request, encodings []string) int {
+	identity := slices.IndexFunc(encodings, func(coding string) bool {
+		return strings.EqualFold(coding, identityCoding)
+	})
+	if !req.Headers.Has(acceptEncodingFieldName) {
+		return max(identity, 0)
+	}
+	accept, err := req.Headers.QualityList(acceptEncodingFieldName)
+	if err != nil {
+		return 0
+	}
+	if len(accept) == 0 {
+		// no content coding is wanted, RFC 9110 section 12.5.3
+		return identity
+	}
+	return pick(len(encodings), func(i int) float64 {
+		q := weigh(accept, func(rng string) int { return codingMatch(rng, encodings[i]) })
+		if q < 0 && strings.EqualFold(encodings[i], identityCoding) {
+			// identity is acceptable unless the client rules it out
+			return 1
+		}
+		return q
+	})
+}
+
+// qualities returns the list in fieldName, or nil when there is nothing
+// usable in it.
+func qualities(req *Request, fieldName string) []headers.Weighted {
+	list, err := req.Headers.QualityList(fieldName)
+	if err != nil {
+		return nil
+	}
+	return list
+}
+
+// pick returns the index of the offer with the highest positive weight, the
+// earliest on a tie, or -1 when every weight is 0.
+func pick(n int, weight func(i int) float64) int {
+	best, bestQ := -1, 0.0
+	for i := range n {
+		if q := weight(i); q > bestQ {
+			best, bestQ = i, q
+		}
+	}
+	return best
+}
+
+// weigh returns the weight of the most specific range in accept that matches
+// an offer, according to match, which gives -1 for no match and otherwise a
+// specificity. An empty list weighs every offer 1, a non-empty list without a
+// match gives -1.
+func weigh(accept []headers.Weighted, match func(rng string) int) float64 {
+	if len(accept) == 0 {
+		return 1
+	}
+	q, specificity := -1.0, -1
+	for _, w := range accept {
+		if s := match(w.Value); s > specificity {
+			q, specificity = w.Q, s
+		}
+	}
+	return q
+}
+
+// mediaRangeMatch matches a range like text/*, */* or text/html;level=1
+// against m, with more specific ranges scoring higher.
+func mediaRangeMatch(rng string, m headers.MediaType) int {
+	r, err := headers.ParseMediaType(rng)
+	switch {
+	case err != nil:
+		return -1
+	case r.Type == anyValue && r.Subtype == anyValue:
+		return 0
+	case r.Type == m.Type && r.Subtype == anyValue:
+		return 1
+	case r.Essence() != m.Essence():
+		return -1
+	}
+	for _, p := range r.Params {
+		if !strings.EqualFold(m.Param(p.Name), p.Value) {
+			return -1
+		}
+	}
+	return 2 + len(r.Params)
+}
+
+func codingMatch(rng, coding string) int {
+	switch {
+	case strings.EqualFold(rng, coding):
+		return 1
+	case rng == anyValue:
+		return 0
+	default:
+		return -1
+	}
+}
+
+// languageRangeMatch matches a range like en or en-US against tag by prefix,
+// so en matches en-GB, with longer ranges scoring higher.
+func languageRangeMatch(rng, tag string) int {
+	switch {
+	case rng == anyValue:
+		return 0
+	case strings.EqualFold(rng, tag):
+		return len(rng)
+	case len(tag) > len(rng) && tag[len(rng)] == '-' && strings.EqualFold(tag[:len(rng)], rng):
+		return len(rng)
+	default:
+		return -1
+	}
+}
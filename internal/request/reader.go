@@ -0,0 +1,122 @@
+package request
+
+import (
+	"errors"
+	"httpfromtcp/internal/headers"
+	"io"
+)
+
+// Reader reads successive requests from one connection. Bytes read past the
+// end of a request are kept and used as the start of the next one, so
+// pipelined requests are returned in the order they were sent.
+type Reader struct {
+	src         io.Reader
+	buf         []byte
+	readToIndex int
+	current     *body
+	limits      Limits
+	mode        headers.ParseMode
+}
+
+func NewReader(src io.Reader) *Reader {
+	return &Reader{src: src, buf: make([]byte, bufferSize), limits: DefaultLimits}
+}
+
+// SetLimits replaces DefaultLimits for the requests read from here on. Zero
+// fields keep their default.
+func (rr *Reader) SetLimits(limits Limits) {
+	rr.limits = limits.withDefaults()
+}
+
+// SetParseMode sets how strictly the heads of the requests read from here on
+// are parsed. It is headers.ParseStrict by default. Chunked bodies are always
+// framed strictly, as a lenient reading of them is easily turned into request
+// smuggling.
+func (rr *Reader) SetParseMode(mode headers.ParseMode) {
+	rr.mode = mode
+}
+
+// ReadRequest parses the request line and headers of the next request and
+// returns with the body still on the connection, to be read through
+// BodyReader. Whatever the caller left unread of the previous body is
+// discarded first. It returns io.EOF if the connection was closed cleanly
+// before any byte of a new request.
+func (rr *Reader) ReadRequest() (*Request, error) {
+	if err := rr.skipBody(); err != nil {
+		return nil, err
+	}
+	req := newRequest(rr.limits, rr.mode)
+	for {
+		parsed, err := req.parse(rr.buffered())
+		if err != nil {
+			return nil, err
+		}
+		rr.consume(parsed)
+		if !req.parsingHead() {
+			break
+		}
+		err = rr.fill()
+		if errors.Is(err, io.EOF) && (req.state != requestStateInitialized || rr.readToIndex > 0) {
+			return nil, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	rr.current = newBody(rr, req)
+	req.BodyReader = rr.current
+	return req, nil
+}
+
+// Wait blocks until the first byte of the next request has arrived, after
+// discarding what is left of the previous body. It returns io.EOF if the
+// connection was closed first.
+func (rr *Reader) Wait() error {
+	if err := rr.skipBody(); err != nil {
+		return err
+	}
+	for rr.readToIndex == 0 {
+		if err := rr.fill(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (rr *Reader) skipBody() error {
+	if rr.current == nil {
+		return nil
+	}
+	if err := rr.current.drain(); err != nil {
+		return err
+	}
+	rr.current = nil
+	return nil
+}
+
+func (rr *Reader) buffered() []byte {
+	return rr.buf[:rr.readToIndex]
+}
+
+func (rr *Reader) consume(n int) {
+	copied := copy(rr.buf, rr.buf[n:rr.readToIndex])
+	clear(rr.buf[copied:rr.readToIndex])
+	rr.readToIndex = copied
+}
+
+// fill reads more from the connection into the buffer, growing it if full.
+func (rr *Reader) fill() error {
+	if rr.readToIndex == len(rr.buf) {
+		newbuf := make([]byte, 2*len(rr.buf))
+		copy(newbuf, rr.buf)
+		rr.buf = newbuf
+	}
+	read, err := rr.src.Read(rr.buf[rr.readToIndex:])
+	rr.readToIndex += read
+	if read > 0 {
+		return nil
+	}
+	if err == nil {
+		return io.ErrNoProgress
+	}
+	return err
+}
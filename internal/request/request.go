@@ -1,6 +1,7 @@
 package request
 
 import (
+	"errors"
 	"fmt"
 	"httpfromtcp/internal/headers"
 	"io"
@@ -16,23 +17,57 @@ const (
 	requestStateInitialized    parseState = 1
 	requestStateParsingHeaders parseState = 2
 	requestStateParsingBody    parseState = 3
+	requestStateParsingChunked parseState = 4
 	lineEnd                               = "\r\n"
 	lineEndLen                            = len(lineEnd)
 	contentLengthFieldName     string     = "content-length"
+	connectionFieldName        string     = "connection"
+	transferEncodingFieldName  string     = "transfer-encoding"
+	teFieldName                string     = "te"
+	chunkedCoding                         = "chunked"
 	bufferSize                            = 8
+	http10Version                         = "1.0"
+)
+
+var (
+	// ErrVersionNotSupported is returned for a request whose major HTTP
+	// version is not 1.
+	ErrVersionNotSupported = errors.New("HTTP version not supported")
+	// ErrAmbiguousFraming is returned for a request whose body could be told
+	// apart from the next request in more than one way. Nothing read after
+	// it on the connection can be trusted.
+	ErrAmbiguousFraming = errors.New("ambiguous message framing")
+	// ErrUnsupportedTransferCoding is returned for a transfer coding other
+	// than chunked, which calls for a 501.
+	ErrUnsupportedTransferCoding = errors.New("unsupported transfer coding")
 )
 
 type Request struct {
 	RequestLine RequestLine
-	Headers     headers.Headers
+	URL         URL
+	Headers     *headers.Headers
 	Body        []byte
+	BodyReader  io.ReadCloser
+	Trailers    *headers.Headers
 	state       parseState
+	limits      Limits
+	mode        headers.ParseMode
+	headerBytes int
+	headerCount int
+	bodyLength  int64
+	chunked     *chunkedDecoder
+	pathValues  map[string]string
+	query       Query
+	bodyErr     error
 }
 
-func newRequest() *Request {
+func newRequest(limits Limits, mode headers.ParseMode) *Request {
 	var request Request
 	request.state = requestStateInitialized
+	request.limits = limits
+	request.mode = mode
 	request.Headers = headers.NewHeaders()
+	request.Trailers = headers.NewHeaders()
 	return &request
 }
 
@@ -42,16 +77,18 @@ type RequestLine struct {
 	Method        string
 }
 
+// parse consumes the request line and headers. The body is left on the
+// connection for BodyReader.
 func (r *Request) parse(data []byte) (int, error) {
-	if r.state == requestStateDone {
-		return 0, fmt.Errorf("cannot parse done request")
+	if !r.parsingHead() {
+		return 0, fmt.Errorf("cannot parse request past its headers")
 	}
 	parsedBytes := 0
-	for r.state != requestStateDone {
+	for r.parsingHead() {
 		n, err := r.parseNext(data[parsedBytes:])
 		parsedBytes += n
 		if err != nil {
-			return parsedBytes, fmt.Errorf("'%v' parsing from byte %d", err, parsedBytes)
+			return parsedBytes, fmt.Errorf("'%w' parsing from byte %d", err, parsedBytes)
 		}
 		if n == 0 {
 			break
@@ -65,40 +102,52 @@ func (r *Request) parseNext(data []byte) (int, error) {
 	case requestStateDone:
 		return 0, fmt.Errorf("cannot parse done request")
 	case requestStateInitialized:
-		n, line, err := parseRequestLine(data)
+		n, line, err := parseRequestLine(data, r.mode)
 		if err != nil {
 			return 0, err
 		}
-		if n > 0 {
-			r.RequestLine = line
-			r.state = requestStateParsingHeaders
+		if n == 0 {
+			// no line end yet, but the line can already be too long
+			return 0, r.limits.checkRequestLine(len(data))
+		}
+		if err := r.limits.checkRequestLine(n - lineEndLen); err != nil {
+			return 0, err
 		}
+		url, query, err := parseTarget(line.Method, line.RequestTarget)
+		if err != nil {
+			return 0, err
+		}
+		r.RequestLine = line
+		r.URL = url
+		r.query = query
+		r.state = requestStateParsingHeaders
 		return n, nil
 	case requestStateParsingHeaders:
-		n, done, err := r.Headers.Parse(data)
+		n, done, err := r.Headers.ParseWith(data, r.mode)
 		if err != nil {
 			return 0, err
 		}
-		if done {
-			r.state = requestStateParsingBody
+		if n == 0 {
+			return 0, r.limits.checkHeaders(r.headerBytes+len(data), r.headerCount)
 		}
-		return n, nil
-	case requestStateParsingBody:
-		lengthStr, err := r.Headers.Get(contentLengthFieldName)
-		if err != nil {
-			r.state = requestStateDone
-			return 0, nil
+		r.headerBytes += n
+		if !done {
+			r.headerCount++
 		}
-		length, err := strconv.Atoi(lengthStr)
-		if err != nil {
+		if err := r.limits.checkHeaders(r.headerBytes, r.headerCount); err != nil {
 			return 0, err
 		}
-		n := len(data)
-		r.Body = append(r.Body, data...)
-		if len(r.Body) > length {
-			return n, fmt.Errorf("content length header value was %d but body is %d bytes long", length, len(r.Body))
-		} else if len(r.Body) == length {
-			r.state = requestStateDone
+		if done {
+			if err := r.checkHost(); err != nil {
+				return 0, err
+			}
+			if r.URL.Host != "" {
+				// the target names the host, whatever Host says
+				r.Headers.Set("Host", r.URL.Host)
+			}
+			if err := r.startBody(); err != nil {
+				return 0, err
+			}
 		}
 		return n, nil
 	default:
@@ -106,48 +155,217 @@ func (r *Request) parseNext(data []byte) (int, error) {
 	}
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize)
-	req := newRequest()
-	readToIndex := 0
-	for req.state != requestStateDone {
-		if readToIndex == len(buf) {
-			newbuf := make([]byte, 2*len(buf))
-			copy(newbuf, buf)
-			buf = newbuf
-		}
-		read, err := reader.Read(buf[readToIndex:])
-		if err != nil {
-			return nil, err
+// checkHost rejects a request with more than one Host field or an invalid
+// one, and an HTTP/1.1 request without one, as RFC 9112 section 3.2 requires.
+// An empty Host is allowed for targets without an authority.
+func (r *Request) checkHost() error {
+	values := r.Headers.Values(hostFieldName)
+	switch {
+	case len(values) > 1:
+		return fmt.Errorf("more than one Host field")
+	case len(values) == 0 && !r.HTTP10():
+		return fmt.Errorf("no Host field")
+	case len(values) == 1 && values[0] != "":
+		if _, _, err := splitHost(values[0]); err != nil {
+			return err
 		}
-		readToIndex += read
-		parsed, err := req.parse(buf[:readToIndex])
+	}
+	return nil
+}
+
+// startBody works out how the body is framed once the headers are complete.
+// Anything that a server or proxy in front of this one could frame another
+// way is rejected, as that is what request smuggling relies on.
+func (r *Request) startBody() error {
+	lengths := r.Headers.Values(contentLengthFieldName)
+	codings := r.Headers.Values(transferEncodingFieldName)
+	switch {
+	case len(lengths) > 0 && len(codings) > 0:
+		return fmt.Errorf("%w: both Content-Length and Transfer-Encoding present", ErrAmbiguousFraming)
+	case len(codings) > 0 && r.HTTP10():
+		// HTTP/1.0 has no transfer codings, so whoever forwarded this may
+		// have framed it by its length or by the connection closing
+		return fmt.Errorf("%w: Transfer-Encoding in an HTTP/1.0 request", ErrAmbiguousFraming)
+	case len(codings) > 0:
+		if err := checkTransferCodings(codings); err != nil {
+			return err
+		}
+		r.chunked = newChunkedDecoder(r.Trailers, r.limits)
+		r.state = requestStateParsingChunked
+	case len(lengths) > 0:
+		length, err := parseContentLength(lengths)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		if req.state != requestStateDone {
-			copied := copy(buf, buf[parsed:])
-			clear(buf[copied:])
-			readToIndex -= parsed
+		if err := r.limits.checkBody(length); err != nil {
+			return err
 		}
+		r.bodyLength = length
+		r.state = requestStateParsingBody
+		if length == 0 {
+			r.state = requestStateDone
+		}
+	default:
+		r.state = requestStateDone
 	}
-	return req, nil
+	return nil
 }
 
-func parseRequestLine(header []byte) (int, RequestLine, error) {
-	dataStr := string(header)
-	index := strings.Index(dataStr, lineEnd)
-	if index < 0 {
-		return 0, RequestLine{}, nil
+// parseContentLength reads the body length from every Content-Length value.
+// The same length repeated, in separate fields or as a list, is accepted as
+// RFC 9110 section 8.6 allows; differing lengths are not.
+func parseContentLength(values []string) (int64, error) {
+	length := int64(-1)
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			element = strings.Trim(element, " \t")
+			n, err := strconv.ParseInt(element, 10, 64)
+			if err != nil || !allDigits(element) {
+				return 0, fmt.Errorf("invalid Content-Length '%s'", value)
+			}
+			if length >= 0 && n != length {
+				return 0, fmt.Errorf("%w: conflicting Content-Length values '%s'", ErrAmbiguousFraming, strings.Join(values, ", "))
+			}
+			length = n
+		}
 	}
-	req, _, found := strings.Cut(string(header), lineEnd)
-	if !found {
-		return 0, RequestLine{}, fmt.Errorf("request line not found")
+	return length, nil
+}
+
+// checkTransferCodings checks that the codings across every Transfer-Encoding
+// value come down to a single chunked, the only one supported.
+func checkTransferCodings(values []string) error {
+	var codings []string
+	for _, value := range values {
+		for _, coding := range strings.Split(value, ",") {
+			if coding = strings.Trim(coding, " \t"); coding != "" {
+				codings = append(codings, coding)
+			}
+		}
+	}
+	if len(codings) == 0 {
+		return fmt.Errorf("%w: empty Transfer-Encoding", ErrAmbiguousFraming)
+	}
+	for i, coding := range codings {
+		if !strings.EqualFold(coding, chunkedCoding) {
+			return fmt.Errorf("%w '%s'", ErrUnsupportedTransferCoding, coding)
+		}
+		if i > 0 {
+			return fmt.Errorf("%w: chunked applied more than once", ErrAmbiguousFraming)
+		}
+	}
+	return nil
+}
+
+// allDigits reports whether s is one or more ASCII digits, with none of the
+// signs strconv would take.
+func allDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
 	}
+	return s != ""
+}
+
+// KeepAlive reports whether the client is willing to send another request on
+// the same connection once this one has been answered. HTTP/1.0 clients have
+// to ask for it with "Connection: keep-alive".
+func (r *Request) KeepAlive() bool {
+	if r.Headers.HasToken(connectionFieldName, "close") {
+		return false
+	}
+	return !r.HTTP10() || r.Headers.HasToken(connectionFieldName, "keep-alive")
+}
+
+// HTTP10 reports whether the request is HTTP/1.0, so the response cannot be
+// chunked.
+func (r *Request) HTTP10() bool {
+	return r.RequestLine.HttpVersion == http10Version
+}
+
+// Cookies returns the cookies the client sent in its Cookie fields.
+func (r *Request) Cookies() []headers.Cookie {
+	return r.Headers.Cookies()
+}
+
+// Cookie returns the first cookie called name, if the client sent one.
+func (r *Request) Cookie(name string) (headers.Cookie, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return headers.Cookie{}, false
+}
+
+// AcceptsTrailers reports whether the client sent "TE: trailers", saying it
+// will not discard trailer fields in a chunked response.
+func (r *Request) AcceptsTrailers() bool {
+	return r.Headers.HasToken(teFieldName, "trailers")
+}
+
+// Query returns the parameters in the query string of the request target.
+func (r *Request) Query() Query {
+	return r.query
+}
+
+// PathValue returns the value a router captured for the named wildcard in the
+// matched path pattern, or "" if there is none.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = make(map[string]string)
+	}
+	r.pathValues[name] = value
+}
+
+// BodyError returns the error that stopped BodyReader, such as
+// ErrBodyTooLarge, or nil if the body has been read without one so far.
+func (r *Request) BodyError() error {
+	return r.bodyErr
+}
+
+func (r *Request) parsingHead() bool {
+	return r.state == requestStateInitialized || r.state == requestStateParsingHeaders
+}
+
+// ReadBody reads whatever is left of the body into Body and returns it. It is
+// the opt-in alternative to streaming from BodyReader.
+func (r *Request) ReadBody() ([]byte, error) {
+	if r.BodyReader == nil {
+		return r.Body, nil
+	}
+	data, err := io.ReadAll(r.BodyReader)
+	r.Body = append(r.Body, data...)
+	return r.Body, err
+}
+
+// RequestFromReader reads a single request, body included, into memory.
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	req, err := NewReader(reader).ReadRequest()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := req.ReadBody(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func parseRequestLine(header []byte, mode headers.ParseMode) (int, RequestLine, error) {
+	line, n, err := mode.CutLine(header)
+	if err != nil || n == 0 {
+		return 0, RequestLine{}, err
+	}
+	req := string(line)
 	var parsed RequestLine
 	method, remain, found := strings.Cut(req, " ")
 	parsed.Method = method
-	if !found || !allUpper(parsed.Method) {
+	if !found || !validMethod(parsed.Method) {
 		return 0, RequestLine{}, fmt.Errorf("invalid method %s", parsed.Method)
 	}
 	middle, verStr, found := cutLast(remain, " ")
@@ -155,11 +373,11 @@ func parseRequestLine(header []byte) (int, RequestLine, error) {
 		return 0, RequestLine{}, fmt.Errorf("no version string")
 	}
 	verParts := strings.Split(verStr, "/")
-	if len(verParts) != 2 || verParts[0] != "HTTP" {
+	if len(verParts) != 2 || verParts[0] != "HTTP" || !validVersion(verParts[1]) {
 		return 0, RequestLine{}, fmt.Errorf("invalid version string %s", verStr)
 	}
-	if verParts[1] != "1.1" {
-		return 0, RequestLine{}, fmt.Errorf("unsupported version %s", verParts[1])
+	if verParts[1][0] != '1' {
+		return 0, RequestLine{}, fmt.Errorf("%w: %s", ErrVersionNotSupported, verParts[1])
 	}
 	if strings.Contains(middle, " ") {
 		return 0, RequestLine{}, fmt.Errorf("invalid request target %s", middle)
@@ -167,16 +385,18 @@ func parseRequestLine(header []byte) (int, RequestLine, error) {
 	parsed.HttpVersion = verParts[1]
 	parsed.RequestTarget = middle
 
-	return index + lineEndLen, parsed, nil
+	return n, parsed, nil
 }
 
-func allUpper(str string) bool {
-	for _, r := range str {
-		if !unicode.IsUpper(r) {
-			return false
-		}
-	}
-	return true
+// validVersion reports whether version is a major and minor digit, like 1.1.
+func validVersion(version string) bool {
+	return len(version) == 3 && unicode.IsDigit(rune(version[0])) && version[1] == '.' && unicode.IsDigit(rune(version[2]))
+}
+
+// validMethod reports whether method is a token without lower case letters.
+// Methods are case-sensitive and every registered one is upper case.
+func validMethod(method string) bool {
+	return headers.IsToken(method) && strings.ToUpper(method) == method
 }
 
 func cutLast(str string, sep string) (before, after string, found bool) {
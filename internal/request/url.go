@@ -0,0 +1,270 @@
+package request
+
+import (
+	"fmt"
+	"iter"
+	"strings"
+)
+
+// TargetForm is the form of a request target, RFC 9112 section 3.2.
+type TargetForm int
+
+const (
+	// TargetOrigin is an absolute path and query, like /where?q=now.
+	TargetOrigin TargetForm = 0
+	// TargetAbsolute is a whole URI, like http://example.com/where, as sent
+	// to proxies.
+	TargetAbsolute TargetForm = 1
+	// TargetAuthority is a host and port, like example.com:443, for CONNECT.
+	TargetAuthority TargetForm = 2
+	// TargetAsterisk is "*", for a server-wide OPTIONS.
+	TargetAsterisk TargetForm = 3
+	connectMethod             = "CONNECT"
+	optionsMethod             = "OPTIONS"
+)
+
+// URL is the parsed request target.
+type URL struct {
+	Form TargetForm
+	// Scheme is "http" or "https" for an absolute-form target, and Host its
+	// authority or that of an authority-form target.
+	Scheme string
+	Host   string
+	// Path is percent-decoded with its dot-segments removed, so it never
+	// climbs above "/" and never holds a "." or ".." segment.
+	Path string
+	// RawPath and RawQuery are the path and query as sent.
+	RawPath  string
+	RawQuery string
+}
+
+// Query holds query parameters in the order they were sent, keeping every
+// value of a repeated name.
+type Query struct {
+	params []queryParam
+}
+
+type queryParam struct {
+	name  string
+	value string
+}
+
+// parseTarget parses a request target in whichever form it is in, checking
+// that the form is one method may use.
+func parseTarget(method, target string) (URL, Query, error) {
+	switch {
+	case method == connectMethod:
+		url, err := parseAuthorityTarget(target)
+		return url, Query{}, err
+	case target == "*":
+		if method != optionsMethod {
+			return URL{}, Query{}, fmt.Errorf("asterisk-form target with %s", method)
+		}
+		return URL{Form: TargetAsterisk}, Query{}, nil
+	case strings.HasPrefix(target, "/"):
+		return parseOriginTarget(target)
+	default:
+		return parseAbsoluteTarget(target)
+	}
+}
+
+// parseAbsoluteTarget parses an absolute-form target such as
+// http://example.com/a?x=1.
+func parseAbsoluteTarget(target string) (URL, Query, error) {
+	scheme, rest, found := strings.Cut(target, "://")
+	scheme = strings.ToLower(scheme)
+	if !found || (scheme != "http" && scheme != "https") {
+		return URL{}, Query{}, fmt.Errorf("invalid request target '%s'", target)
+	}
+	end := strings.IndexAny(rest, "/?")
+	if end < 0 {
+		end = len(rest)
+	}
+	authority, rest := rest[:end], rest[end:]
+	if strings.Contains(authority, "@") {
+		return URL{}, Query{}, fmt.Errorf("user info in request target '%s'", target)
+	}
+	if _, _, err := splitHost(authority); err != nil {
+		return URL{}, Query{}, err
+	}
+	if !strings.HasPrefix(rest, "/") {
+		// an empty path is the root
+		rest = "/" + rest
+	}
+	url, query, err := parseOriginTarget(rest)
+	if err != nil {
+		return URL{}, Query{}, err
+	}
+	url.Form, url.Scheme, url.Host = TargetAbsolute, scheme, authority
+	return url, query, nil
+}
+
+// parseAuthorityTarget parses the host and port a CONNECT is for.
+func parseAuthorityTarget(target string) (URL, error) {
+	if strings.ContainsAny(target, "/?#@") {
+		return URL{}, fmt.Errorf("CONNECT needs an authority-form target, not '%s'", target)
+	}
+	_, port, err := splitHost(target)
+	if err != nil {
+		return URL{}, err
+	}
+	if port == "" {
+		return URL{}, fmt.Errorf("no port in CONNECT target '%s'", target)
+	}
+	return URL{Form: TargetAuthority, Host: target}, nil
+}
+
+// parseOriginTarget parses an origin-form target such as /a/b?x=1.
+func parseOriginTarget(target string) (URL, Query, error) {
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+	if !strings.HasPrefix(rawPath, "/") {
+		return URL{}, Query{}, fmt.Errorf("request target '%s' does not start with /", target)
+	}
+	if strings.Contains(rawQuery, "#") || strings.Contains(rawPath, "#") {
+		return URL{}, Query{}, fmt.Errorf("fragment in request target '%s'", target)
+	}
+	path, err := unescape(rawPath, false)
+	if err != nil {
+		return URL{}, Query{}, err
+	}
+	if strings.ContainsRune(path, 0) {
+		return URL{}, Query{}, fmt.Errorf("NUL in request target '%s'", target)
+	}
+	query, err := ParseQuery(rawQuery)
+	if err != nil {
+		return URL{}, Query{}, err
+	}
+	return URL{Path: removeDotSegments(path), RawPath: rawPath, RawQuery: rawQuery}, query, nil
+}
+
+// removeDotSegments resolves "." and ".." segments in an absolute path, as in
+// RFC 3986 section 5.2.4. A ".." at the root stays at the root.
+func removeDotSegments(path string) string {
+	segments := strings.Split(path[1:], "/")
+	out := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch segment {
+		case ".":
+		case "..":
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, segment)
+			continue
+		}
+		if last {
+			// "/a/b/.." names the directory /a/
+			out = append(out, "")
+		}
+	}
+	return "/" + strings.Join(out, "/")
+}
+
+// ParseQuery parses a query string such as a=1&b=2&a=3. A "+" stands for a
+// space, as in HTML forms.
+func ParseQuery(rawQuery string) (Query, error) {
+	var q Query
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		rawName, rawValue, _ := strings.Cut(pair, "=")
+		name, err := unescape(rawName, true)
+		if err != nil {
+			return Query{}, err
+		}
+		value, err := unescape(rawValue, true)
+		if err != nil {
+			return Query{}, err
+		}
+		q.params = append(q.params, queryParam{name: name, value: value})
+	}
+	return q, nil
+}
+
+// Get returns the first value of name, or "" if it is not present.
+func (q Query) Get(name string) string {
+	for _, p := range q.params {
+		if p.name == name {
+			return p.value
+		}
+	}
+	return ""
+}
+
+// Values returns every value of name in the order they were sent.
+func (q Query) Values(name string) []string {
+	var values []string
+	for _, p := range q.params {
+		if p.name == name {
+			values = append(values, p.value)
+		}
+	}
+	return values
+}
+
+func (q Query) Has(name string) bool {
+	for _, p := range q.params {
+		if p.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of parameters, counting each value of a repeated
+// name.
+func (q Query) Len() int {
+	return len(q.params)
+}
+
+// All yields every name and value in order.
+func (q Query) All() iter.Seq2[string, string] {
+	return func(yield func(string, string) bool) {
+		for _, p := range q.params {
+			if !yield(p.name, p.value) {
+				return
+			}
+		}
+	}
+}
+
+// unescape decodes percent-encoding, and "+" as a space if plusSpace is set.
+func unescape(s string, plusSpace bool) (string, error) {
+	if !strings.ContainsAny(s, "%+") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '%':
+			if i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+				return "", fmt.Errorf("invalid percent-encoding in '%s'", s)
+			}
+			b.WriteByte(unhex(s[i+1])<<4 | unhex(s[i+2]))
+			i += 2
+		case s[i] == '+' && plusSpace:
+			b.WriteByte(' ')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	default:
+		return c - '0'
+	}
+}
@@ -0,0 +1,29 @@
+package response
+
+import (
+	"httpfromtcp/internal/headers"
+	"sync/atomic"
+	"time"
+)
+
+// clock is the time source for Date, replaced in tests.
+var clock = time.Now
+
+type cachedDate struct {
+	second int64
+	value  string
+}
+
+var lastDate atomic.Pointer[cachedDate]
+
+// currentDate returns the Date value for now, formatting it at most once a
+// second however many responses are written.
+func currentDate() string {
+	now := clock()
+	if d := lastDate.Load(); d != nil && d.second == now.Unix() {
+		return d.value
+	}
+	d := &cachedDate{second: now.Unix(), value: headers.FormatHTTPDate(now)}
+	lastDate.Store(d)
+	return d.value
+}
@@ -1,11 +1,14 @@
 package response
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"httpfromtcp/internal/headers"
 	"io"
+	"slices"
+	"strconv"
 	"strings"
-	"unicode"
 )
 
 type StatusCode int
@@ -13,112 +16,538 @@ type StatusCode int
 type writerState int
 
 const (
-	HTTPOk                     StatusCode  = 200
-	HTTPBadRequest             StatusCode  = 400
-	HTTPInternalServerError    StatusCode  = 500
-	hTTPOkStr                              = "OK"
-	hTTPBadRequestStr                      = "Bad Request"
-	hTTPInternalServerErrorStr             = "Internal Server Error"
-	headerLineEnd                          = "\r\n"
-	writerStateStatusLine      writerState = 0
-	writerStateHeaders         writerState = 1
-	writerStateBody            writerState = 2
-	writerStateTrailers        writerState = 3
-	writerStateDone            writerState = 4
+	headerLineEnd                     = "\r\n"
+	dateFieldName                     = "Date"
+	trailerFieldName                  = "Trailer"
+	bodyWindowSize                    = 4096
+	writerStateStatusLine writerState = 0
+	writerStateHeaders    writerState = 1
+	writerStateBody       writerState = 2
+	writerStateTrailers   writerState = 3
+	writerStateDone       writerState = 4
 )
 
+// ErrTrailersNotAccepted is returned by WriteTrailers when the client did not
+// send "TE: trailers". The body is ended without the trailers.
+var ErrTrailersNotAccepted = errors.New("client does not accept trailers")
+
 type Writer struct {
-	out   io.Writer
-	state writerState
+	out            *bufio.Writer
+	conn           *sentCounter
+	state          writerState
+	keepAlive      bool
+	chunked        bool
+	contentLength  int
+	written        int
+	status         StatusCode
+	extra          *headers.Headers
+	header         *headers.Headers
+	headerErr      error
+	pending        *headers.Headers // held back until the framing is picked
+	window         []byte
+	trailers       []string // declared in the Trailer header
+	acceptTrailers bool
+	http10         bool
+	head           bool
+}
+
+// sentCounter counts the bytes that get through to the connection.
+type sentCounter struct {
+	dst  io.Writer
+	sent int64
+}
+
+func (c *sentCounter) Write(p []byte) (int, error) {
+	n, err := c.dst.Write(p)
+	c.sent += int64(n)
+	return n, err
 }
 
 func NewWriter(writer io.Writer) *Writer {
-	return &Writer{out: writer, state: writerStateStatusLine}
+	conn := &sentCounter{dst: writer}
+	return &Writer{out: bufio.NewWriter(conn), conn: conn, state: writerStateStatusLine, keepAlive: true, contentLength: -1}
+}
+
+// Sent reports whether any of the response has gone out to the connection.
+// Until it has, Reset can still replace the response.
+func (w *Writer) Sent() bool {
+	return w.conn.sent > 0
+}
+
+// Reset discards a response that has not been sent, so that another can be
+// written in its place. The settings made with SetHeader, SetKeepAlive,
+// SetHTTP10, SetHeadRequest and SetAcceptTrailers are kept.
+func (w *Writer) Reset() error {
+	if w.Sent() {
+		return fmt.Errorf("calling Reset after the response was sent")
+	}
+	w.out.Reset(w.conn)
+	*w = Writer{
+		out:            w.out,
+		conn:           w.conn,
+		state:          writerStateStatusLine,
+		keepAlive:      w.keepAlive,
+		contentLength:  -1,
+		extra:          w.extra,
+		acceptTrailers: w.acceptTrailers,
+		http10:         w.http10,
+		head:           w.head,
+	}
+	return nil
+}
+
+// SetHeader adds a field to the headers written later by WriteHeaders,
+// unless the handler sets that field itself.
+func (w *Writer) SetHeader(fieldName, fieldValue string) {
+	if w.extra == nil {
+		w.extra = headers.NewHeaders()
+	}
+	w.extra.Add(fieldName, fieldValue)
+}
+
+// Header returns the fields sent by WriteHeader or, when the handler does
+// not write its headers itself, with the first part of the body. Changes made
+// after that have no effect.
+func (w *Writer) Header() *headers.Headers {
+	if w.header == nil {
+		w.header = headers.NewHeaders()
+	}
+	return w.header
+}
+
+// WriteHeader writes the status line and the fields from Header. Calling it
+// is optional: writing a body or finishing the response without it sends a
+// 200.
+func (w *Writer) WriteHeader(statusCode StatusCode) error {
+	if err := w.WriteStatusLine(statusCode); err != nil {
+		return err
+	}
+	// a copy, so that Header stays as set for a final response after a 1xx
+	return w.WriteHeaders(w.Header().Clone())
+}
+
+// implicitHeaders writes what the handler skipped before the body: a 200
+// status line and the fields from Header.
+func (w *Writer) implicitHeaders() error {
+	switch w.state {
+	case writerStateStatusLine:
+		return w.WriteHeader(HTTPOk)
+	case writerStateHeaders:
+		if w.headerErr != nil {
+			return w.headerErr
+		}
+		return w.WriteHeaders(w.Header().Clone())
+	default:
+		return nil
+	}
+}
+
+// SetHTTP10 tells the writer that the client speaks HTTP/1.0. The response is
+// then sent as HTTP/1.0, without chunked framing or interim responses, and
+// says "Connection: keep-alive" when the connection stays open.
+func (w *Writer) SetHTTP10(http10 bool) {
+	w.http10 = http10
+}
+
+// SetHeadRequest tells the writer that it is answering a HEAD request. The
+// headers are written as they would be for a GET, framing included, but the
+// body and any trailers are discarded.
+func (w *Writer) SetHeadRequest(head bool) {
+	w.head = head
+}
+
+// SetAcceptTrailers tells the writer whether the client accepts trailer
+// fields, which WriteTrailers otherwise leaves out.
+func (w *Writer) SetAcceptTrailers(accept bool) {
+	w.acceptTrailers = accept
+}
+
+// Status returns the status code written, or 0 before WriteStatusLine.
+func (w *Writer) Status() StatusCode {
+	return w.status
+}
+
+// SetKeepAlive tells the writer whether the connection may be reused after
+// this response. When it may not, WriteHeaders adds "Connection: close".
+func (w *Writer) SetKeepAlive(keepAlive bool) {
+	w.keepAlive = keepAlive
 }
 
-func formatStatusLine(statusCode StatusCode) []byte {
-	return []byte(fmt.Sprintf("HTTP/1.1 %d %s%s", statusCode, hTTPStatuses[statusCode], headerLineEnd))
+// KeepAlive reports whether a complete, self-delimited response has been
+// written and the connection can carry another request.
+func (w *Writer) KeepAlive() bool {
+	if !w.keepAlive || w.state != writerStateDone {
+		return false
+	}
+	return w.chunked || w.contentLength == w.written || !w.status.AllowsBody()
 }
 
+// Finish completes a response the handler left open: missing headers are
+// written as for the body, a chunked body gets its last chunk and an empty
+// trailer section, a plain body is marked done. Either way anything still
+// buffered is flushed to the connection.
+func (w *Writer) Finish() error {
+	if err := w.implicitHeaders(); err != nil {
+		return err
+	}
+	switch {
+	case w.pending != nil:
+		// the whole body fit in the window, so its length is known
+		if err := w.commit(false); err != nil {
+			return err
+		}
+		w.state = writerStateDone
+		return w.out.Flush()
+	case w.state == writerStateBody && w.chunked:
+		if _, err := w.WriteChunkedBodyDone(); err != nil {
+			return err
+		}
+		return w.WriteTrailers(headers.NewHeaders())
+	case w.state == writerStateTrailers && w.chunked:
+		return w.WriteTrailers(headers.NewHeaders())
+	default:
+		w.state = writerStateDone
+		return w.out.Flush()
+	}
+}
+
+func formatStatusLine(version string, statusCode StatusCode, reason string) []byte {
+	return []byte(fmt.Sprintf("HTTP/%s %d %s%s", version, statusCode, reason, headerLineEnd))
+}
+
+// WriteStatusLine writes the status line with the registered reason phrase
+// for statusCode, which is left empty for unregistered codes.
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineReason(statusCode, statusCode.Reason())
+}
+
+// WriteStatusLineReason writes the status line with a custom reason phrase.
+func (w *Writer) WriteStatusLineReason(statusCode StatusCode, reason string) error {
 	if w.state != writerStateStatusLine {
 		return fmt.Errorf("calling WriteStatusLine more than once")
 	}
-	_, err := w.out.Write(formatStatusLine(statusCode))
+	if !statusCode.valid() {
+		return fmt.Errorf("invalid status code %d", statusCode)
+	}
+	if err := validReason(reason); err != nil {
+		return err
+	}
+	if w.http10 && statusCode.IsInformational() {
+		return fmt.Errorf("HTTP/1.0 clients do not take interim responses")
+	}
+	version := "1.1"
+	if w.http10 {
+		version = "1.0"
+	}
+	_, err := w.out.Write(formatStatusLine(version, statusCode, reason))
 	if err == nil {
 		w.state = writerStateHeaders
+		w.status = statusCode
 	}
 	return err
 }
 
-func GetDefaultHeaders(contentLen int) headers.Headers {
-	header := make(headers.Headers)
+func GetDefaultHeaders(contentLen int) *headers.Headers {
+	header := headers.NewHeaders()
 	if contentLen >= 0 {
-		header["Content-Length"] = fmt.Sprintf("%d", contentLen)
+		header.Set("Content-Length", fmt.Sprintf("%d", contentLen))
 	}
-	header["Connection"] = "close"
-	header["Content-Type"] = "text/plain"
+	header.SetContentType(headers.TextPlain)
 	return header
 }
 
-func formatHeaders(headers headers.Headers) []byte {
-	var headerStr string
-	for k, v := range headers {
-		name := strings.TrimRightFunc(k, func(r rune) bool { return unicode.IsSpace(r) })
-		headerStr += fmt.Sprintf("%s: %s%s", name, v, headerLineEnd)
+// writeHeaders writes the fields in the order they were added, with
+// canonical names, after checking that none of them can split the response.
+func (w *Writer) writeHeaders(header *headers.Headers) error {
+	if err := validateHeaders(header); err != nil {
+		return err
+	}
+	for k, v := range header.All() {
+		w.out.WriteString(headers.CanonicalName(k))
+		w.out.WriteString(": ")
+		w.out.WriteString(v)
+		w.out.WriteString(headerLineEnd)
 	}
-	headerStr += headerLineEnd
-	return []byte(headerStr)
+	_, err := w.out.WriteString(headerLineEnd)
+	return err
+}
+
+func validateHeaders(header *headers.Headers) error {
+	for k, v := range header.All() {
+		if err := headers.ValidateField(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
+func (w *Writer) WriteHeaders(header *headers.Headers) error {
 	if w.state < writerStateHeaders {
 		return fmt.Errorf("calling WriteHeaders before writing status line")
 	} else if w.state > writerStateHeaders {
 		return fmt.Errorf("calling WriteHeaders more than once")
 	}
-	//fmt.Println("no ", string(formatHeaders(headers)))
-	_, err := w.out.Write(formatHeaders(headers))
-	if err == nil {
+	var extra [][2]string
+	for _, from := range []*headers.Headers{w.header, w.extra} {
+		if from == nil || from == header {
+			continue
+		}
+		for k, v := range from.All() {
+			if !header.Has(k) {
+				extra = append(extra, [2]string{k, v})
+			}
+		}
+	}
+	for _, f := range extra {
+		header.Add(f[0], f[1])
+	}
+	if !header.Has(dateFieldName) && !w.status.IsInformational() {
+		// origin servers must send Date, 1xx responses may leave it out
+		header.Set(dateFieldName, currentDate())
+	}
+	if w.http10 {
+		// HTTP/1.0 has no chunked framing, and so no trailers either
+		header.Del("Transfer-Encoding")
+		header.Del(trailerFieldName)
+	}
+	for _, name := range header.TrailerNames() {
+		if headers.ForbiddenTrailer(name) {
+			w.headerErr = fmt.Errorf("field %s cannot be a trailer", name)
+			return w.headerErr
+		}
+	}
+	if header.Has(trailerFieldName) && !header.Has("Content-Length") && !header.Has("Transfer-Encoding") {
+		// only a chunked body can carry trailers, there is nothing to wait for
+		header.Set("Transfer-Encoding", "chunked")
+	}
+	if w.status.AllowsBody() && !header.Has("Content-Length") && !header.Has("Transfer-Encoding") {
+		// the framing is picked once it is known whether the body fits the window
+		if err := validateHeaders(header); err != nil {
+			w.headerErr = err
+			return err
+		}
+		w.pending = header
+		w.state = writerStateBody
+		return nil
+	}
+	w.frame(header)
+	err := w.writeHeaders(header)
+	if err != nil {
+		w.headerErr = err
+	} else {
 		w.state = writerStateBody
+		if w.status.IsInformational() {
+			// an interim response, the final one still has to follow
+			w.state = writerStateStatusLine
+			err = w.out.Flush()
+		}
 	}
 	return err
 }
 
+// frame records how the body will be delimited and marks the connection for
+// closing when the client cannot otherwise tell where the response ends.
+func (w *Writer) frame(header *headers.Headers) {
+	if header.HasToken("Connection", "close") {
+		w.keepAlive = false
+	}
+	if !w.status.AllowsBody() {
+		if w.status.IsInformational() || w.status == HTTPNoContent {
+			header.Del("Content-Length")
+			header.Del("Transfer-Encoding")
+		}
+		w.setConnection(header)
+		return
+	}
+	if header.HasToken("Transfer-Encoding", "chunked") {
+		w.chunked = true
+		w.trailers = header.TrailerNames()
+	} else if value, err := header.Get("Content-Length"); err == nil {
+		length, err := strconv.Atoi(value)
+		if err != nil {
+			length = -1
+		}
+		w.contentLength = length
+	}
+	if !w.chunked && w.contentLength < 0 {
+		w.keepAlive = false
+	}
+	w.setConnection(header)
+}
+
+// setConnection adds a Connection field when the client would otherwise
+// assume the wrong thing about the connection staying open.
+func (w *Writer) setConnection(header *headers.Headers) {
+	switch {
+	case header.Has("Connection"):
+	case !w.keepAlive:
+		header.Set("Connection", "close")
+	case w.http10:
+		header.Set("Connection", "keep-alive")
+	}
+}
+
+// WriteBody writes p as the whole of what is left of the body. A chunked
+// body is ended after it, leaving only the trailers to write.
 func (w *Writer) WriteBody(p []byte) (int, error) {
-	if w.state < writerStateBody {
-		return 0, fmt.Errorf("calling WriteBody before writing preceeding sections")
-	} else if w.state > writerStateBody {
+	if err := w.implicitHeaders(); err != nil {
+		return 0, err
+	}
+	if w.state > writerStateBody {
 		return 0, fmt.Errorf("calling WriteBody more than once")
 	}
-	n, err := w.out.Write(p)
+	if len(p) > 0 && !w.status.AllowsBody() {
+		return 0, fmt.Errorf("status %d does not allow a body", w.status)
+	}
+	var n int
+	var err error
+	switch {
+	case w.pending != nil:
+		w.window = append(w.window, p...)
+		n, err = len(p), w.commit(false)
+	case w.chunked:
+		// p is the rest of the body, so it is followed by the last chunk
+		if len(p) > 0 {
+			n, err = w.writeChunk(p)
+		}
+		if err == nil {
+			_, err = w.writeBody([]byte("0" + headerLineEnd))
+		}
+	default:
+		n, err = w.writeBody(p)
+		w.written += n
+	}
+	if err == nil {
+		err = w.out.Flush()
+	}
 	if err == nil {
 		w.state = writerStateTrailers
 	}
 	return n, err
 }
 
+// Write adds p to the body and may be called any number of times. When the
+// headers gave no Content-Length or Transfer-Encoding, the first
+// bodyWindowSize bytes are held back: a body that ends within them is sent
+// with its Content-Length, a longer one is sent chunked.
+func (w *Writer) Write(p []byte) (int, error) {
+	if err := w.implicitHeaders(); err != nil {
+		return 0, err
+	}
+	if w.state > writerStateBody {
+		return 0, fmt.Errorf("calling Write after the body is done")
+	}
+	if len(p) > 0 && !w.status.AllowsBody() {
+		return 0, fmt.Errorf("status %d does not allow a body", w.status)
+	}
+	switch {
+	case w.pending != nil:
+		w.window = append(w.window, p...)
+		if len(w.window) > bodyWindowSize {
+			if err := w.commit(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	case w.chunked:
+		if len(p) == 0 {
+			// an empty chunk would end the body
+			return 0, nil
+		}
+		if _, err := w.writeChunk(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	default:
+		if w.contentLength >= 0 && w.written+len(p) > w.contentLength {
+			return 0, fmt.Errorf("body longer than Content-Length %d", w.contentLength)
+		}
+		n, err := w.writeBody(p)
+		w.written += n
+		return n, err
+	}
+}
+
+// Flush sends everything written so far. Headers still held back are sent
+// with chunked framing, since more of the body may follow.
+func (w *Writer) Flush() error {
+	if w.pending != nil {
+		if err := w.commit(true); err != nil {
+			return err
+		}
+	}
+	return w.out.Flush()
+}
+
+// commit writes the held back headers with the framing picked for them,
+// chunked or the length of the window, followed by the window. An HTTP/1.0
+// body that cannot be chunked is delimited by closing the connection.
+func (w *Writer) commit(chunked bool) error {
+	header, window := w.pending, w.window
+	w.pending, w.window = nil, nil
+	if !chunked {
+		header.Set("Content-Length", strconv.Itoa(len(window)))
+	} else if !w.http10 {
+		header.Set("Transfer-Encoding", "chunked")
+	}
+	w.frame(header)
+	if err := w.writeHeaders(header); err != nil {
+		return err
+	}
+	if len(window) == 0 {
+		return nil
+	}
+	if w.chunked {
+		_, err := w.writeChunk(window)
+		return err
+	}
+	n, err := w.writeBody(window)
+	w.written += n
+	return err
+}
+
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
-	if w.state < writerStateBody {
-		return 0, fmt.Errorf("calling WriteBody before writing preceeding sections")
-	} else if w.state > writerStateBody {
+	if err := w.implicitHeaders(); err != nil {
+		return 0, err
+	}
+	if w.state > writerStateBody {
 		return 0, fmt.Errorf("calling WriteBody more than once")
 	}
+	if !w.status.AllowsBody() {
+		return 0, fmt.Errorf("status %d does not allow a body", w.status)
+	}
+	if w.pending != nil {
+		if err := w.commit(true); err != nil {
+			return 0, err
+		}
+	}
+	var n int
+	var err error
+	if w.chunked {
+		n, err = w.writeChunk(p)
+	} else {
+		n, err = w.writeBody(p)
+		w.written += n
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, w.out.Flush()
+}
+
+func (w *Writer) writeChunk(p []byte) (int, error) {
 	lenStr := fmt.Sprintf("%x%s", len(p), headerLineEnd)
-	n, err := w.out.Write([]byte(lenStr))
+	n, err := w.writeBody([]byte(lenStr))
 	if err != nil {
 		return 0, err
 	}
-	m, err := w.out.Write(p)
+	m, err := w.writeBody(p)
 	if err != nil {
 		return n, err
 	}
 	n += m
-	m, err = w.out.Write([]byte(headerLineEnd))
-	if err != nil {
-		return n, err
-	}
-	return n + m, nil
+	m, err = w.writeBody([]byte(headerLineEnd))
+	return n + m, err
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
@@ -127,9 +556,19 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	} else if w.state > writerStateBody {
 		return 0, fmt.Errorf("calling WriteBody more than once")
 	}
+	if w.pending != nil {
+		if err := w.commit(true); err != nil {
+			return 0, err
+		}
+	}
+	if !w.chunked {
+		// an HTTP/1.0 body, which ends when the connection does
+		w.state = writerStateTrailers
+		return 0, nil
+	}
 	//str := fmt.Sprintf("0%s%s", headerLineEnd, headerLineEnd)
 	str := fmt.Sprintf("0%s", headerLineEnd)
-	n, err := w.out.Write([]byte(str))
+	n, err := w.writeBody([]byte(str))
 	if err != nil {
 		return 0, err
 	}
@@ -137,26 +576,62 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	return n, nil
 }
 
-func (w *Writer) WriteTrailers(headers headers.Headers) error {
+// WriteTrailers ends a chunked body with trailer fields, each of which must
+// have been declared in the Trailer header. When the client does not accept
+// trailers the body is ended without them and ErrTrailersNotAccepted is
+// returned.
+func (w *Writer) WriteTrailers(trailers *headers.Headers) error {
 	if w.state < writerStateTrailers {
-		return fmt.Errorf("calling WriteHeaders before writing body")
+		return fmt.Errorf("calling WriteTrailers before writing body")
 	} else if w.state > writerStateTrailers {
-		return fmt.Errorf("calling WriteHeaders more than once")
+		return fmt.Errorf("calling WriteTrailers more than once")
 	}
-	//fmt.Println("no ", string(formatHeaders(headers)))
-	_, err := w.out.Write(formatHeaders(headers))
-	if err == nil {
+	if !w.chunked && w.http10 {
+		// the body could not be chunked, so there is nowhere to put trailers
 		w.state = writerStateDone
+		if err := w.out.Flush(); err != nil {
+			return err
+		}
+		if trailers.Len() > 0 {
+			return ErrTrailersNotAccepted
+		}
+		return nil
 	}
-	//w.out.Write([]byte("\r\n"))
-	return err
+	if !w.chunked {
+		return fmt.Errorf("trailers need a chunked body")
+	}
+	for name := range trailers.All() {
+		if headers.ForbiddenTrailer(name) {
+			return fmt.Errorf("field %s cannot be a trailer", name)
+		}
+		declared := func(d string) bool { return strings.EqualFold(d, name) }
+		if !slices.ContainsFunc(w.trailers, declared) {
+			return fmt.Errorf("trailer %s not declared in the Trailer header", name)
+		}
+	}
+	var dropped error
+	if trailers.Len() > 0 && !w.acceptTrailers {
+		trailers, dropped = headers.NewHeaders(), ErrTrailersNotAccepted
+	}
+	var err error
+	if !w.head {
+		err = w.writeHeaders(trailers)
+	}
+	if err == nil {
+		err = w.out.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	w.state = writerStateDone
+	return dropped
 }
 
-var hTTPStatuses map[StatusCode]string
-
-func init() {
-	hTTPStatuses = make(map[StatusCode]string)
-	hTTPStatuses[HTTPOk] = hTTPOkStr
-	hTTPStatuses[HTTPBadRequest] = hTTPBadRequestStr
-	hTTPStatuses[HTTPInternalServerError] = hTTPInternalServerErrorStr
+// writeBody writes body bytes and their chunk framing, which a response to
+// HEAD leaves out.
+func (w *Writer) writeBody(p []byte) (int, error) {
+	if w.head {
+		return len(p), nil
+	}
+	return w.out.Write(p)
 }
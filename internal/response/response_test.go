@@ -0,0 +1,508 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"httpfromtcp/internal/headers"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusLine(t *testing.T) {
+	// Test: Registered reason phrase
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(HTTPTooManyRequests))
+	require.NoError(t, w.out.Flush())
+	assert.Equal(t, "HTTP/1.1 429 Too Many Requests\r\n", out.String())
+
+	// Test: Unregistered code gets an empty reason phrase
+	out.Reset()
+	w = NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(299))
+	require.NoError(t, w.out.Flush())
+	assert.Equal(t, "HTTP/1.1 299 \r\n", out.String())
+
+	// Test: Custom reason phrase
+	out.Reset()
+	w = NewWriter(&out)
+	require.NoError(t, w.WriteStatusLineReason(HTTPOk, "Totally Fine"))
+	require.NoError(t, w.out.Flush())
+	assert.Equal(t, "HTTP/1.1 200 Totally Fine\r\n", out.String())
+
+	// Test: Reason phrase with a line break
+	w = NewWriter(&out)
+	require.Error(t, w.WriteStatusLineReason(HTTPOk, "OK\r\nX-Injected: 1"))
+
+	// Test: Code outside three digits
+	w = NewWriter(&out)
+	require.Error(t, w.WriteStatusLine(42))
+	require.Error(t, w.WriteStatusLine(1000))
+}
+
+func TestStatusClasses(t *testing.T) {
+	assert.True(t, HTTPContinue.IsInformational())
+	assert.True(t, HTTPNoContent.IsSuccess())
+	assert.True(t, HTTPPermanentRedirect.IsRedirect())
+	assert.False(t, HTTPOk.IsRedirect())
+	assert.True(t, HTTPNotFound.IsClientError())
+	assert.True(t, HTTPVersionNotSupported.IsServerError())
+	assert.False(t, HTTPVersionNotSupported.IsClientError())
+	assert.Equal(t, "Unprocessable Content", HTTPUnprocessableContent.Reason())
+	assert.Equal(t, "", StatusCode(299).Reason())
+	for _, code := range []StatusCode{HTTPContinue, HTTPEarlyHints, HTTPNoContent, HTTPNotModified} {
+		assert.False(t, code.AllowsBody(), "%d", code)
+	}
+	assert.True(t, HTTPResetContent.AllowsBody())
+}
+
+// fixClock makes Date always Sun, 06 Nov 1994 08:49:37 GMT for the test.
+func fixClock(t *testing.T) {
+	clock = func() time.Time { return time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC) }
+	t.Cleanup(func() { clock = time.Now })
+}
+
+func TestBodylessStatus(t *testing.T) {
+	fixClock(t)
+	// Test: 204 refuses a body and drops framing headers
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(HTTPNoContent))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	_, err := w.WriteBody([]byte("nope"))
+	require.Error(t, err)
+	_, err = w.WriteChunkedBody([]byte("nope"))
+	require.Error(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+	assert.NotContains(t, out.String(), "Content-Length")
+
+	// Test: 304 keeps Content-Length but sends no body
+	out.Reset()
+	w = NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(HTTPNotModified))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(1234)))
+	_, err = w.WriteBody([]byte("nope"))
+	require.Error(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+	assert.Contains(t, out.String(), "Content-Length: 1234\r\n")
+
+	// Test: Interim 1xx response followed by the final one
+	out.Reset()
+	w = NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(HTTPContinue))
+	require.NoError(t, w.WriteHeaders(headers.NewHeaders()))
+	require.NoError(t, w.WriteStatusLine(HTTPOk))
+	final := headers.NewHeaders()
+	final.Set("Content-Length", "2")
+	require.NoError(t, w.WriteHeaders(final))
+	_, err = w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\nDate: Sun, 06 Nov 1994 08:49:37 GMT\r\n\r\nok", out.String())
+}
+
+func TestHeaderSerialization(t *testing.T) {
+	fixClock(t)
+	// Test: Fields written in insertion order with canonical names
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	header := headers.NewHeaders()
+	header.Set("content-length", "5")
+	header.Set("CONTENT-TYPE", "text/plain")
+	header.Add("set-cookie", "a=1")
+	header.Add("x-request-id", "abc")
+	header.Add("Set-Cookie", "b=2")
+	require.NoError(t, w.WriteStatusLine(HTTPOk))
+	require.NoError(t, w.WriteHeaders(header))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+
+		"Content-Length: 5\r\n"+
+		"Content-Type: text/plain\r\n"+
+		"Set-Cookie: a=1\r\n"+
+		"X-Request-Id: abc\r\n"+
+		"Set-Cookie: b=2\r\n"+
+		"Date: Sun, 06 Nov 1994 08:49:37 GMT\r\n"+
+		"\r\n"+
+		"hello", out.String())
+
+	// Test: Same headers give the same bytes every time
+	for range 10 {
+		var again bytes.Buffer
+		w = NewWriter(&again)
+		require.NoError(t, w.WriteStatusLine(HTTPOk))
+		require.NoError(t, w.WriteHeaders(header))
+		_, err = w.WriteBody([]byte("hello"))
+		require.NoError(t, err)
+		assert.Equal(t, out.String(), again.String())
+	}
+
+	// Test: Value with CR/LF rejected before anything is sent
+	out.Reset()
+	w = NewWriter(&out)
+	header = GetDefaultHeaders(0)
+	header.Set("Location", "/next\r\nSet-Cookie: admin=1")
+	require.NoError(t, w.WriteStatusLine(HTTPFound))
+	require.Error(t, w.WriteHeaders(header))
+	require.Error(t, w.Finish())
+	assert.Empty(t, out.String())
+
+	// Test: Trailer values checked the same way
+	out.Reset()
+	w = NewWriter(&out)
+	w.SetAcceptTrailers(true)
+	header = headers.NewHeaders()
+	header.Set("Transfer-Encoding", "chunked")
+	header.AddTrailers([]string{"X-Checksum"})
+	require.NoError(t, w.WriteStatusLine(HTTPOk))
+	require.NoError(t, w.WriteHeaders(header))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("x-checksum", "abc\n")
+	require.Error(t, w.WriteTrailers(trailers))
+	trailers.Set("x-checksum", "abc")
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Checksum\r\nDate: Sun, 06 Nov 1994 08:49:37 GMT\r\n\r\n0\r\nX-Checksum: abc\r\n\r\n", out.String())
+}
+
+func TestTrailers(t *testing.T) {
+	start := func(out *bytes.Buffer, accept bool, trailerNames ...string) *Writer {
+		w := NewWriter(out)
+		w.SetAcceptTrailers(accept)
+		w.Header().AddTrailers(trailerNames)
+		require.NoError(t, w.WriteHeader(HTTPOk))
+		return w
+	}
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+
+	// Test: Declared trailers make the body chunked
+	var out bytes.Buffer
+	w := start(&out, true, "X-Checksum", "X-Length")
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(trailers))
+	assert.True(t, w.KeepAlive())
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, "abc", resp.Trailer.Get("X-Checksum"))
+
+	// Test: Undeclared and forbidden trailers rejected, the body still ends
+	out.Reset()
+	w = start(&out, true, "X-Length")
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.Error(t, w.WriteTrailers(trailers))
+	forbidden := headers.NewHeaders()
+	forbidden.Set("Content-Length", "5")
+	require.Error(t, w.WriteTrailers(forbidden))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n0\r\n\r\n"))
+
+	// Test: Forbidden field cannot be declared
+	w = NewWriter(&out)
+	w.Header().AddTrailers([]string{"X-Checksum", "Host"})
+	require.Error(t, w.WriteHeader(HTTPOk))
+
+	// Test: Trailers left out for a client without TE: trailers
+	out.Reset()
+	w = start(&out, false, "X-Checksum")
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.ErrorIs(t, w.WriteTrailers(trailers), ErrTrailersNotAccepted)
+	assert.True(t, w.KeepAlive())
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n0\r\n\r\n"))
+
+	// Test: No trailers after a Content-Length body
+	out.Reset()
+	w = NewWriter(&out)
+	w.SetAcceptTrailers(true)
+	require.NoError(t, w.WriteStatusLine(HTTPOk))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(2)))
+	_, err = w.WriteBody([]byte("ok"))
+	require.NoError(t, err)
+	require.Error(t, w.WriteTrailers(trailers))
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+}
+
+func TestDate(t *testing.T) {
+	// Test: Date added once per response and cached within a second
+	now := time.Date(2026, time.October, 18, 9, 30, 0, 0, time.FixedZone("CEST", 7200))
+	clock = func() time.Time { return now }
+	t.Cleanup(func() { clock = time.Now })
+	header := GetDefaultHeaders(0)
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(HTTPOk))
+	require.NoError(t, w.WriteHeaders(header))
+	assert.Equal(t, []string{"Sun, 18 Oct 2026 07:30:00 GMT"}, header.Values("Date"))
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(t, "Sun, 18 Oct 2026 07:30:00 GMT", currentDate())
+	now = now.Add(time.Second)
+	assert.Equal(t, "Sun, 18 Oct 2026 07:30:01 GMT", currentDate())
+
+	// Test: Date set by the handler kept
+	header = GetDefaultHeaders(0)
+	header.Set("Date", "Tue, 15 Nov 1994 08:12:31 GMT")
+	w = NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(HTTPOk))
+	require.NoError(t, w.WriteHeaders(header))
+	assert.Equal(t, []string{"Tue, 15 Nov 1994 08:12:31 GMT"}, header.Values("Date"))
+
+	// Test: No Date on interim responses
+	header = headers.NewHeaders()
+	w = NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(HTTPContinue))
+	require.NoError(t, w.WriteHeaders(header))
+	assert.False(t, header.Has("Date"))
+}
+
+func TestWrite(t *testing.T) {
+	fixClock(t)
+	start := func(out *bytes.Buffer) *Writer {
+		w := NewWriter(out)
+		require.NoError(t, w.WriteStatusLine(HTTPOk))
+		require.NoError(t, w.WriteHeaders(GetDefaultHeaders(-1)))
+		return w
+	}
+
+	// Test: Body within the window sent with its length
+	var out bytes.Buffer
+	w := start(&out)
+	require.NoError(t, json.NewEncoder(w).Encode(map[string]int{"answer": 42}))
+	_, err := io.WriteString(w, "more")
+	require.NoError(t, err)
+	assert.Empty(t, out.String())
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: text/plain; charset=utf-8\r\nDate: Sun, 06 Nov 1994 08:49:37 GMT\r\nContent-Length: 18\r\n\r\n{\"answer\":42}\nmore", out.String())
+
+	// Test: Empty body gets Content-Length 0
+	out.Reset()
+	w = start(&out)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "Content-Length: 0\r\n\r\n")
+	assert.True(t, w.KeepAlive())
+
+	// Test: Body past the window sent chunked
+	out.Reset()
+	w = start(&out)
+	big := strings.Repeat("x", bodyWindowSize+1)
+	n, err := io.Copy(w, strings.NewReader(big))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(big)), n)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, big, string(body))
+
+	// Test: Flush sends the headers chunked before the body is complete
+	out.Reset()
+	w = start(&out)
+	_, err = w.Write([]byte("first"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.True(t, strings.HasSuffix(out.String(), "Transfer-Encoding: chunked\r\n\r\n5\r\nfirst\r\n"))
+	_, err = w.Write([]byte("second"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(out.String(), "5\r\nfirst\r\n6\r\nsecond\r\n0\r\n\r\n"))
+
+	// Test: WriteBody after streaming chunks ends the chunked body
+	out.Reset()
+	w = start(&out)
+	_, err = w.Write([]byte(big))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte("end"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n3\r\nend\r\n0\r\n\r\n"))
+	resp, err = http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, big+"end", string(body))
+
+	// Test: Response to HEAD keeps the framing and drops the body
+	for _, size := range []int{5, bodyWindowSize + 1} {
+		out.Reset()
+		w = start(&out)
+		w.SetHeadRequest(true)
+		_, err = w.Write([]byte(strings.Repeat("@", size)))
+		require.NoError(t, err)
+		require.NoError(t, w.Finish())
+		assert.True(t, w.KeepAlive())
+		assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"))
+		assert.NotContains(t, out.String(), "@")
+	}
+	assert.Contains(t, out.String(), "Transfer-Encoding: chunked\r\n")
+
+	// Test: Declared Content-Length enforced
+	out.Reset()
+	w = NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(HTTPOk))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(3)))
+	_, err = w.Write([]byte("ab"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("cd"))
+	require.Error(t, err)
+	_, err = w.Write([]byte("c"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\nabc"))
+}
+
+func TestImplicitHeaders(t *testing.T) {
+	fixClock(t)
+	date := "Date: Sun, 06 Nov 1994 08:49:37 GMT\r\n"
+
+	// Test: First write sends a 200 with the fields from Header
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	w.Header().SetContentType(headers.ApplicationJSON)
+	_, err := w.Write([]byte(`{}`))
+	require.NoError(t, err)
+	w.Header().Set("X-Too-Late", "1")
+	require.NoError(t, w.Finish())
+	assert.Equal(t, HTTPOk, w.Status())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n"+date+"Content-Length: 2\r\n\r\n{}", out.String())
+
+	// Test: Nothing written at all
+	out.Reset()
+	w = NewWriter(&out)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n"+date+"Content-Length: 0\r\n\r\n", out.String())
+
+	// Test: WriteHeader with a status and no body
+	out.Reset()
+	w = NewWriter(&out)
+	w.Header().Set("Location", "/elsewhere")
+	require.NoError(t, w.WriteHeader(HTTPSeeOther))
+	require.Error(t, w.WriteHeader(HTTPOk))
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 303 See Other\r\nLocation: /elsewhere\r\n"+date+"Content-Length: 0\r\n\r\n", out.String())
+
+	// Test: Status line without headers, and Header merged into explicit ones
+	out.Reset()
+	w = NewWriter(&out)
+	w.Header().Set("X-From-Header", "yes")
+	require.NoError(t, w.WriteStatusLine(HTTPAccepted))
+	_, err = w.WriteBody([]byte("queued"))
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "HTTP/1.1 202 Accepted\r\nX-From-Header: yes\r\n")
+	out.Reset()
+	w = NewWriter(&out)
+	w.Header().Set("X-From-Header", "yes")
+	require.NoError(t, w.WriteStatusLine(HTTPOk))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	require.NoError(t, w.Finish())
+	assert.Contains(t, out.String(), "X-From-Header: yes\r\n")
+
+	// Test: Interim response leaves Header for the final one
+	out.Reset()
+	w = NewWriter(&out)
+	w.Header().Set("Link", "</style.css>; rel=preload")
+	require.NoError(t, w.WriteHeader(HTTPEarlyHints))
+	w.Header().Set("X-Final", "1")
+	require.NoError(t, w.Finish())
+	assert.Equal(t, "HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n"+
+		"HTTP/1.1 200 OK\r\nLink: </style.css>; rel=preload\r\nX-Final: 1\r\n"+date+"Content-Length: 0\r\n\r\n", out.String())
+
+	// Test: Reset replaces a response that has not been sent
+	out.Reset()
+	w = NewWriter(&out)
+	w.SetHeader("Server", "test")
+	w.Header().Set("X-Dropped", "1")
+	require.NoError(t, w.WriteHeader(HTTPOk))
+	_, err = w.Write([]byte("partial"))
+	require.NoError(t, err)
+	assert.False(t, w.Sent())
+	require.NoError(t, w.Reset())
+	require.NoError(t, w.WriteHeader(HTTPNotFound))
+	require.NoError(t, w.Finish())
+	assert.True(t, w.Sent())
+	assert.Equal(t, "HTTP/1.1 404 Not Found\r\nServer: test\r\n"+date+"Content-Length: 0\r\n\r\n", out.String())
+	assert.Error(t, w.Reset())
+}
+
+func TestHTTP10(t *testing.T) {
+	fixClock(t)
+	start := func(out *bytes.Buffer) *Writer {
+		w := NewWriter(out)
+		w.SetHTTP10(true)
+		return w
+	}
+
+	// Test: Short body gets a Content-Length and keep-alive is spelled out
+	var out bytes.Buffer
+	w := start(&out)
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, w.KeepAlive())
+	assert.Equal(t, "HTTP/1.0 200 OK\r\n"+
+		"Date: Sun, 06 Nov 1994 08:49:37 GMT\r\n"+
+		"Content-Length: 5\r\n"+
+		"Connection: keep-alive\r\n"+
+		"\r\n"+
+		"hello", out.String())
+
+	// Test: Long body is not chunked but ends with the connection
+	out.Reset()
+	w = start(&out)
+	body := strings.Repeat("x", bodyWindowSize+1)
+	_, err = w.Write([]byte(body))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.False(t, w.KeepAlive())
+	head, rest, _ := strings.Cut(out.String(), "\r\n\r\n")
+	assert.Contains(t, head, "Connection: close")
+	assert.NotContains(t, head, "Transfer-Encoding")
+	assert.Equal(t, body, rest)
+
+	// Test: Chunked calls and declared trailers fall back to the same
+	out.Reset()
+	w = start(&out)
+	w.Header().AddTrailers([]string{"X-Checksum"})
+	require.NoError(t, w.WriteHeader(HTTPOk))
+	_, err = w.WriteChunkedBody([]byte("hel"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("lo"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	assert.ErrorIs(t, w.WriteTrailers(trailers), ErrTrailersNotAccepted)
+	require.NoError(t, w.Finish())
+	assert.False(t, w.KeepAlive())
+	head, rest, _ = strings.Cut(out.String(), "\r\n\r\n")
+	assert.NotContains(t, head, "Trailer")
+	assert.Equal(t, "hello", rest)
+
+	// Test: No interim responses
+	w = start(&out)
+	require.Error(t, w.WriteStatusLine(HTTPContinue))
+}
@@ -0,0 +1,181 @@
+package response
+
+import "fmt"
+
+// Status codes from the IANA HTTP Status Code Registry.
+const (
+	HTTPContinue                      StatusCode = 100
+	HTTPSwitchingProtocols            StatusCode = 101
+	HTTPProcessing                    StatusCode = 102
+	HTTPEarlyHints                    StatusCode = 103
+	HTTPOk                            StatusCode = 200
+	HTTPCreated                       StatusCode = 201
+	HTTPAccepted                      StatusCode = 202
+	HTTPNonAuthoritativeInformation   StatusCode = 203
+	HTTPNoContent                     StatusCode = 204
+	HTTPResetContent                  StatusCode = 205
+	HTTPPartialContent                StatusCode = 206
+	HTTPMultiStatus                   StatusCode = 207
+	HTTPAlreadyReported               StatusCode = 208
+	HTTPIMUsed                        StatusCode = 226
+	HTTPMultipleChoices               StatusCode = 300
+	HTTPMovedPermanently              StatusCode = 301
+	HTTPFound                         StatusCode = 302
+	HTTPSeeOther                      StatusCode = 303
+	HTTPNotModified                   StatusCode = 304
+	HTTPUseProxy                      StatusCode = 305
+	HTTPTemporaryRedirect             StatusCode = 307
+	HTTPPermanentRedirect             StatusCode = 308
+	HTTPBadRequest                    StatusCode = 400
+	HTTPUnauthorized                  StatusCode = 401
+	HTTPPaymentRequired               StatusCode = 402
+	HTTPForbidden                     StatusCode = 403
+	HTTPNotFound                      StatusCode = 404
+	HTTPMethodNotAllowed              StatusCode = 405
+	HTTPNotAcceptable                 StatusCode = 406
+	HTTPProxyAuthenticationRequired   StatusCode = 407
+	HTTPRequestTimeout                StatusCode = 408
+	HTTPConflict                      StatusCode = 409
+	HTTPGone                          StatusCode = 410
+	HTTPLengthRequired                StatusCode = 411
+	HTTPPreconditionFailed            StatusCode = 412
+	HTTPContentTooLarge               StatusCode = 413
+	HTTPURITooLong                    StatusCode = 414
+	HTTPUnsupportedMediaType          StatusCode = 415
+	HTTPRangeNotSatisfiable           StatusCode = 416
+	HTTPExpectationFailed             StatusCode = 417
+	HTTPMisdirectedRequest            StatusCode = 421
+	HTTPUnprocessableContent          StatusCode = 422
+	HTTPLocked                        StatusCode = 423
+	HTTPFailedDependency              StatusCode = 424
+	HTTPTooEarly                      StatusCode = 425
+	HTTPUpgradeRequired               StatusCode = 426
+	HTTPPreconditionRequired          StatusCode = 428
+	HTTPTooManyRequests               StatusCode = 429
+	HTTPRequestHeaderFieldsTooLarge   StatusCode = 431
+	HTTPUnavailableForLegalReasons    StatusCode = 451
+	HTTPInternalServerError           StatusCode = 500
+	HTTPNotImplemented                StatusCode = 501
+	HTTPBadGateway                    StatusCode = 502
+	HTTPServiceUnavailable            StatusCode = 503
+	HTTPGatewayTimeout                StatusCode = 504
+	HTTPVersionNotSupported           StatusCode = 505
+	HTTPVariantAlsoNegotiates         StatusCode = 506
+	HTTPInsufficientStorage           StatusCode = 507
+	HTTPLoopDetected                  StatusCode = 508
+	HTTPNotExtended                   StatusCode = 510
+	HTTPNetworkAuthenticationRequired StatusCode = 511
+)
+
+var hTTPStatuses = map[StatusCode]string{
+	HTTPContinue:                      "Continue",
+	HTTPSwitchingProtocols:            "Switching Protocols",
+	HTTPProcessing:                    "Processing",
+	HTTPEarlyHints:                    "Early Hints",
+	HTTPOk:                            "OK",
+	HTTPCreated:                       "Created",
+	HTTPAccepted:                      "Accepted",
+	HTTPNonAuthoritativeInformation:   "Non-Authoritative Information",
+	HTTPNoContent:                     "No Content",
+	HTTPResetContent:                  "Reset Content",
+	HTTPPartialContent:                "Partial Content",
+	HTTPMultiStatus:                   "Multi-Status",
+	HTTPAlreadyReported:               "Already Reported",
+	HTTPIMUsed:                        "IM Used",
+	HTTPMultipleChoices:               "Multiple Choices",
+	HTTPMovedPermanently:              "Moved Permanently",
+	HTTPFound:                         "Found",
+	HTTPSeeOther:                      "See Other",
+	HTTPNotModified:                   "Not Modified",
+	HTTPUseProxy:                      "Use Proxy",
+	HTTPTemporaryRedirect:             "Temporary Redirect",
+	HTTPPermanentRedirect:             "Permanent Redirect",
+	HTTPBadRequest:                    "Bad Request",
+	HTTPUnauthorized:                  "Unauthorized",
+	HTTPPaymentRequired:               "Payment Required",
+	HTTPForbidden:                     "Forbidden",
+	HTTPNotFound:                      "Not Found",
+	HTTPMethodNotAllowed:              "Method Not Allowed",
+	HTTPNotAcceptable:                 "Not Acceptable",
+	HTTPProxyAuthenticationRequired:   "Proxy Authentication Required",
+	HTTPRequestTimeout:                "Request Timeout",
+	HTTPConflict:                      "Conflict",
+	HTTPGone:                          "Gone",
+	HTTPLengthRequired:                "Length Required",
+	HTTPPreconditionFailed:            "Precondition Failed",
+	HTTPContentTooLarge:               "Content Too Large",
+	HTTPURITooLong:                    "URI Too Long",
+	HTTPUnsupportedMediaType:          "Unsupported Media Type",
+	HTTPRangeNotSatisfiable:           "Range Not Satisfiable",
+	HTTPExpectationFailed:             "Expectation Failed",
+	HTTPMisdirectedRequest:            "Misdirected Request",
+	HTTPUnprocessableContent:          "Unprocessable Content",
+	HTTPLocked:                        "Locked",
+	HTTPFailedDependency:              "Failed Dependency",
+	HTTPTooEarly:                      "Too Early",
+	HTTPUpgradeRequired:               "Upgrade Required",
+	HTTPPreconditionRequired:          "Precondition Required",
+	HTTPTooManyRequests:               "Too Many Requests",
+	HTTPRequestHeaderFieldsTooLarge:   "Request Header Fields Too Large",
+	HTTPUnavailableForLegalReasons:    "Unavailable For Legal Reasons",
+	HTTPInternalServerError:           "Internal Server Error",
+	HTTPNotImplemented:                "Not Implemented",
+	HTTPBadGateway:                    "Bad Gateway",
+	HTTPServiceUnavailable:            "Service Unavailable",
+	HTTPGatewayTimeout:                "Gateway Timeout",
+	HTTPVersionNotSupported:           "HTTP Version Not Supported",
+	HTTPVariantAlsoNegotiates:         "Variant Also Negotiates",
+	HTTPInsufficientStorage:           "Insufficient Storage",
+	HTTPLoopDetected:                  "Loop Detected",
+	HTTPNotExtended:                   "Not Extended",
+	HTTPNetworkAuthenticationRequired: "Network Authentication Required",
+}
+
+// Reason returns the registered reason phrase for the code, or "" if it is
+// not registered.
+func (c StatusCode) Reason() string {
+	return hTTPStatuses[c]
+}
+
+func (c StatusCode) IsInformational() bool {
+	return c >= 100 && c < 200
+}
+
+func (c StatusCode) IsSuccess() bool {
+	return c >= 200 && c < 300
+}
+
+func (c StatusCode) IsRedirect() bool {
+	return c >= 300 && c < 400
+}
+
+func (c StatusCode) IsClientError() bool {
+	return c >= 400 && c < 500
+}
+
+func (c StatusCode) IsServerError() bool {
+	return c >= 500 && c < 600
+}
+
+// AllowsBody reports whether a response with this code may carry content,
+// which 1xx, 204 and 304 responses never do.
+func (c StatusCode) AllowsBody() bool {
+	return !c.IsInformational() && c != HTTPNoContent && c != HTTPNotModified
+}
+
+func (c StatusCode) valid() bool {
+	return c >= 100 && c <= 999
+}
+
+// validReason reports whether reason can appear on a status line: tabs,
+// spaces, visible ASCII and obs-text, but no control characters.
+func validReason(reason string) error {
+	for i := 0; i < len(reason); i++ {
+		c := reason[i]
+		if c == '\t' || c == ' ' || (c > ' ' && c != 0x7f) {
+			continue
+		}
+		return fmt.Errorf("invalid character %q in reason phrase", c)
+	}
+	return nil
+}
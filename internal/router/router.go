@@ -0,0 +1,211 @@
+package router
+
+import (
+	"fmt"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"slices"
+	"strings"
+)
+
+type segmentKind int
+
+const (
+	segmentLiteral  segmentKind = 0
+	segmentParam    segmentKind = 1
+	segmentWildcard segmentKind = 2
+	segmentRest     segmentKind = 3
+	wildcard                    = "*"
+	restSuffix                  = "..."
+	allowFieldName              = "Allow"
+)
+
+type segment struct {
+	kind  segmentKind
+	value string
+}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []segment
+	handler  server.Handler
+}
+
+// Router dispatches requests on method and path. Patterns are made of
+// slash-separated segments, each of which is one of:
+//
+//	literal   matches itself
+//	{name}    matches any one segment, available as req.PathValue("name")
+//	*         matches any one segment
+//	{name...} matches the rest of the path, and must come last
+//
+// A pattern ending in a slash matches every path below it. When several
+// patterns match, the one with the more literal leading segments wins.
+type Router struct {
+	routes []*route
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers h for requests with the given method whose path matches
+// pattern. It panics if the pattern is malformed or already registered for
+// the method.
+func (rt *Router) Handle(method, pattern string, h server.Handler) {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	for _, r := range rt.routes {
+		if r.method == method && r.pattern == pattern {
+			panic(fmt.Sprintf("pattern %s already registered for %s", pattern, method))
+		}
+	}
+	rt.routes = append(rt.routes, &route{method: method, pattern: pattern, segments: segments, handler: h})
+}
+
+// Handler returns a server.Handler that dispatches to the registered routes,
+// answering 404 when no pattern matches the path and 405 when patterns match
+// but none for the request's method.
+func (rt *Router) Handler() server.Handler {
+	return rt.serve
+}
+
+func (rt *Router) serve(w *response.Writer, req *request.Request) *server.HandlerError {
+	path := req.URL.Path
+	var best *route
+	var bestValues map[string]string
+	var allowed []string
+	for _, r := range rt.routes {
+		values, ok := r.match(path)
+		if !ok {
+			continue
+		}
+		if r.method != req.RequestLine.Method {
+			allowed = append(allowed, r.method)
+			continue
+		}
+		if best == nil || r.moreSpecific(best) {
+			best = r
+			bestValues = values
+		}
+	}
+	if best == nil && len(allowed) > 0 {
+		return methodNotAllowed(w, allowed)
+	}
+	if best == nil {
+		return &server.HandlerError{Status: response.HTTPNotFound, Message: "Not Found"}
+	}
+	for name, value := range bestValues {
+		req.SetPathValue(name, value)
+	}
+	return best.handler(w, req)
+}
+
+func methodNotAllowed(w *response.Writer, allowed []string) *server.HandlerError {
+	slices.Sort(allowed)
+	allowed = slices.Compact(allowed)
+	body := []byte("Method Not Allowed")
+	err := w.WriteStatusLine(response.HTTPMethodNotAllowed)
+	if err != nil {
+		return &server.HandlerError{Status: response.HTTPInternalServerError, Message: err.Error()}
+	}
+	header := response.GetDefaultHeaders(len(body))
+	header.Set(allowFieldName, strings.Join(allowed, ", "))
+	err = w.WriteHeaders(header)
+	if err != nil {
+		return nil
+	}
+	w.WriteBody(body)
+	return nil
+}
+
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("pattern %s does not start with /", pattern)
+	}
+	parts := strings.Split(pattern[1:], "/")
+	segments := make([]segment, 0, len(parts))
+	for i, part := range parts {
+		last := i == len(parts)-1
+		switch {
+		case part == "" && last:
+			segments = append(segments, segment{kind: segmentRest})
+		case part == "":
+			return nil, fmt.Errorf("empty segment in pattern %s", pattern)
+		case part == wildcard:
+			segments = append(segments, segment{kind: segmentWildcard})
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name := part[1 : len(part)-1]
+			kind := segmentParam
+			if strings.HasSuffix(name, restSuffix) {
+				if !last {
+					return nil, fmt.Errorf("%s is not the last segment in pattern %s", part, pattern)
+				}
+				name = strings.TrimSuffix(name, restSuffix)
+				kind = segmentRest
+			}
+			if name == "" {
+				return nil, fmt.Errorf("unnamed wildcard in pattern %s", pattern)
+			}
+			segments = append(segments, segment{kind: kind, value: name})
+		case strings.ContainsAny(part, "{}"):
+			return nil, fmt.Errorf("bad wildcard %s in pattern %s", part, pattern)
+		default:
+			segments = append(segments, segment{kind: segmentLiteral, value: part})
+		}
+	}
+	return segments, nil
+}
+
+// match reports whether path matches the route's pattern, returning the
+// values captured by its named wildcards.
+func (r *route) match(path string) (map[string]string, bool) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, false
+	}
+	parts := strings.Split(path[1:], "/")
+	var values map[string]string
+	capture := func(name, value string) {
+		if name == "" {
+			return
+		}
+		if values == nil {
+			values = make(map[string]string)
+		}
+		values[name] = value
+	}
+	for i, seg := range r.segments {
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch seg.kind {
+		case segmentRest:
+			capture(seg.value, strings.Join(parts[i:], "/"))
+			return values, true
+		case segmentLiteral:
+			if parts[i] != seg.value {
+				return nil, false
+			}
+		default:
+			if parts[i] == "" {
+				return nil, false
+			}
+			capture(seg.value, parts[i])
+		}
+	}
+	return values, len(parts) == len(r.segments)
+}
+
+// moreSpecific reports whether r should win over other when both match.
+func (r *route) moreSpecific(other *route) bool {
+	for i := 0; i < len(r.segments) && i < len(other.segments); i++ {
+		if r.segments[i].kind != other.segments[i].kind {
+			return r.segments[i].kind < other.segments[i].kind
+		}
+	}
+	return len(r.segments) > len(other.segments)
+}
@@ -0,0 +1,107 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// named answers with its name followed by the path values it was given
+func named(name string, params ...string) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		body := name
+		for _, p := range params {
+			body += " " + p + "=" + req.PathValue(p)
+		}
+		w.WriteStatusLine(response.HTTPOk)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+		return nil
+	}
+}
+
+func serve(t *testing.T, h server.Handler, method, target string) (*http.Response, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	if hErr := h(w, req); hErr != nil {
+		hErr.WriteError(w)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+func TestRouter(t *testing.T) {
+	r := New()
+	r.Handle("GET", "/users", named("list"))
+	r.Handle("POST", "/users", named("create"))
+	r.Handle("GET", "/users/{id}", named("user", "id"))
+	r.Handle("GET", "/users/me", named("me"))
+	r.Handle("DELETE", "/users/{id}", named("delete", "id"))
+	r.Handle("GET", "/users/{id}/*/edit", named("edit", "id"))
+	r.Handle("GET", "/files/{path...}", named("file", "path"))
+	r.Handle("GET", "/static/", named("static"))
+	h := r.Handler()
+
+	tests := []struct {
+		method string
+		target string
+		status int
+		body   string
+	}{
+		{"GET", "/users", 200, "list"},
+		{"POST", "/users", 200, "create"},
+		{"GET", "/users/42", 200, "user id=42"},
+		{"GET", "/users/42?verbose=1", 200, "user id=42"},
+		{"GET", "/users/J%C3%BCrgen%20K", 200, "user id=Jürgen K"},
+		{"GET", "/static/../users/me", 200, "me"},
+		{"GET", "/files/a/../../../users", 200, "list"},
+		{"GET", "/users/./42", 200, "user id=42"},
+		{"GET", "/users/me", 200, "me"},
+		{"DELETE", "/users/me", 200, "delete id=me"},
+		{"GET", "/users/42/profile/edit", 200, "edit id=42"},
+		{"GET", "/files/a/b/c.txt", 200, "file path=a/b/c.txt"},
+		{"GET", "/static/", 200, "static"},
+		{"GET", "/static/css/site.css", 200, "static"},
+		{"GET", "/static", 404, "Not Found"},
+		{"GET", "/users/", 404, "Not Found"},
+		{"GET", "/nowhere", 404, "Not Found"},
+		{"PUT", "/users/42", 405, "Method Not Allowed"},
+	}
+	for _, test := range tests {
+		resp, body := serve(t, h, test.method, test.target)
+		assert.Equal(t, test.status, resp.StatusCode, "%s %s", test.method, test.target)
+		assert.Equal(t, test.body, body, "%s %s", test.method, test.target)
+	}
+
+	// Test: Allow lists every method registered for the path
+	resp, _ := serve(t, h, "PATCH", "/users")
+	assert.Equal(t, "GET, POST", resp.Header.Get("Allow"))
+	resp, _ = serve(t, h, "PUT", "/users/me")
+	assert.Equal(t, "DELETE, GET", resp.Header.Get("Allow"))
+}
+
+func TestBadPatterns(t *testing.T) {
+	r := New()
+	assert.Panics(t, func() { r.Handle("GET", "users", named("x")) })
+	assert.Panics(t, func() { r.Handle("GET", "/a//b", named("x")) })
+	assert.Panics(t, func() { r.Handle("GET", "/{rest...}/b", named("x")) })
+	assert.Panics(t, func() { r.Handle("GET", "/{}", named("x")) })
+	assert.Panics(t, func() { r.Handle("GET", "/a{b}", named("x")) })
+	r.Handle("GET", "/a", named("x"))
+	assert.Panics(t, func() { r.Handle("GET", "/a", named("y")) })
+}
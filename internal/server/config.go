@@ -0,0 +1,84 @@
+package server
+
+import (
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"log"
+	"net"
+	"time"
+)
+
+// Config describes a server for Start. Only Handler is required.
+type Config struct {
+	// Addr is the TCP address to listen on, such as ":42069", or
+	// "127.0.0.1:0" to have the system pick a port. It is ignored when
+	// Listener is set.
+	Addr string
+	// Listener, when set, is used instead of listening on Addr.
+	Listener net.Listener
+	Handler  Handler
+	// Logger receives connection errors. The standard logger is used when
+	// it is nil.
+	Logger *log.Logger
+	// Limits caps the size of requests. Zero fields take their value from
+	// request.DefaultLimits and negative ones mean no limit.
+	Limits request.Limits
+	// ParseMode is how strictly request heads are parsed. The zero value is
+	// headers.ParseStrict; headers.ParseLenient suits old or sloppy clients
+	// talking to the server directly, not from behind a proxy.
+	ParseMode headers.ParseMode
+	Timeouts
+	// MaxConns caps the number of connections served at once. Further
+	// connections wait to be accepted. Zero means no cap.
+	MaxConns int
+	// ErrorRenderer writes the response for a HandlerError. It defaults to
+	// (*HandlerError).WriteError.
+	ErrorRenderer ErrorRenderer
+	// ServerName, when set, is sent in a Server field on every response that
+	// does not set its own.
+	ServerName string
+}
+
+// ErrorRenderer writes the response for e, from a handler or from a request
+// that could not be read.
+type ErrorRenderer func(e *HandlerError, w *response.Writer) error
+
+// Timeouts bound how long a connection may spend in each phase of a request.
+// A zero duration means no timeout.
+type Timeouts struct {
+	// ReadHeaderTimeout runs from the first byte of a request to the end of
+	// its headers. ReadTimeout is used when it is zero.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout runs from the first byte of a request to the end of its
+	// body.
+	ReadTimeout time.Duration
+	// WriteTimeout runs from the end of the request headers to the end of
+	// the response.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a kept-alive connection may wait for its next
+	// request. ReadTimeout is used when it is zero.
+	IdleTimeout time.Duration
+}
+
+func (t Timeouts) readHeader() time.Duration {
+	if t.ReadHeaderTimeout > 0 {
+		return t.ReadHeaderTimeout
+	}
+	return t.ReadTimeout
+}
+
+func (t Timeouts) idle() time.Duration {
+	if t.IdleTimeout > 0 {
+		return t.IdleTimeout
+	}
+	return t.ReadTimeout
+}
+
+// deadline returns the time d after from, or no deadline if d is zero.
+func deadline(from time.Time, d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return from.Add(d)
+}
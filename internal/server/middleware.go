@@ -0,0 +1,102 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"log"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware wraps a Handler to run code before and after it.
+type Middleware func(Handler) Handler
+
+const (
+	RequestIDFieldName = "X-Request-Id"
+	maxRequestIDLen    = 128
+	requestIDBytes     = 16
+)
+
+// Chain wraps h in the middlewares so that the first one listed runs first.
+func Chain(h Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// RequestID makes sure every request carries an X-Request-Id header, keeping
+// a well-formed one sent by the client and generating one otherwise. The ID is
+// echoed back on the response.
+func RequestID() Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) *HandlerError {
+			id, err := req.Headers.Get(RequestIDFieldName)
+			if err != nil || !validRequestID(id) {
+				id = newRequestID()
+				req.Headers.Set(RequestIDFieldName, id)
+			}
+			w.SetHeader(RequestIDFieldName, id)
+			return next(w, req)
+		}
+	}
+}
+
+func newRequestID() string {
+	var id [requestIDBytes]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Recover turns a panicking handler into a 500 response, logging the panic
+// and its stack to logger, or the standard logger if nil.
+func Recover(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) (hErr *HandlerError) {
+			defer func() {
+				if p := recover(); p != nil {
+					logger.Printf("panic serving %s %s: %v\n%s", req.RequestLine.Method, req.RequestLine.RequestTarget, p, debug.Stack())
+					hErr = &HandlerError{Status: response.HTTPInternalServerError, Message: "Internal Server Error"}
+				}
+			}()
+			return next(w, req)
+		}
+	}
+}
+
+// Timing logs the method, target, status and duration of every request to
+// logger, or the standard logger if nil.
+func Timing(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) *HandlerError {
+			start := time.Now()
+			hErr := next(w, req)
+			status := w.Status()
+			if hErr != nil && status == 0 {
+				status = hErr.Status
+			}
+			logger.Printf("%s %s %d %s", req.RequestLine.Method, req.RequestLine.RequestTarget, status, time.Since(start))
+			return hErr
+		}
+	}
+}
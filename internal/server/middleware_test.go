@@ -0,0 +1,83 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runHandler(t *testing.T, h Handler, raw string) *http.Response {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	if hErr := h(w, req); hErr != nil {
+		hErr.WriteError(w)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(&out), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w *response.Writer, req *request.Request) *HandlerError {
+				order = append(order, name)
+				return next(w, req)
+			}
+		}
+	}
+	h := Chain(echoTarget, trace("first"), trace("second"), trace("third"))
+	runHandler(t, h, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, []string{"first", "second", "third"}, order)
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := Chain(func(w *response.Writer, req *request.Request) *HandlerError {
+		seen, _ = req.Headers.Get(RequestIDFieldName)
+		return echoTarget(w, req)
+	}, RequestID())
+
+	// Test: ID generated and echoed
+	resp := runHandler(t, h, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Len(t, seen, 2*requestIDBytes)
+	assert.Equal(t, seen, resp.Header.Get(RequestIDFieldName))
+
+	// Test: Client ID kept
+	resp = runHandler(t, h, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-Id: abc-123\r\n\r\n")
+	assert.Equal(t, "abc-123", seen)
+	assert.Equal(t, "abc-123", resp.Header.Get(RequestIDFieldName))
+
+	// Test: Client ID with bad characters replaced
+	resp = runHandler(t, h, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Request-Id: a b\r\n\r\n")
+	assert.NotEqual(t, "a b", seen)
+	assert.Equal(t, seen, resp.Header.Get(RequestIDFieldName))
+}
+
+func TestRecoverAndTiming(t *testing.T) {
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+	h := Chain(func(w *response.Writer, req *request.Request) *HandlerError {
+		panic("boom")
+	}, Timing(logger), Recover(logger))
+	resp := runHandler(t, h, "GET /explode HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "Internal Server Error", string(body))
+	assert.Contains(t, logs.String(), "panic serving GET /explode: boom")
+	assert.Contains(t, logs.String(), "GET /explode 500 ")
+}
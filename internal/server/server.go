@@ -1,20 +1,49 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"html"
 	"httpfromtcp/internal/headers"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
+	"io"
 	"log"
 	"net"
+	"os"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Server struct {
-	listener net.Listener
-	handler  Handler
+	listener      net.Listener
+	handler       Handler
+	logger        *log.Logger
+	limits        request.Limits
+	parseMode     headers.ParseMode
+	timeouts      Timeouts
+	errorRenderer ErrorRenderer
+	serverName    string
+	slots         chan struct{}
+	closed        atomic.Bool
+	done          chan struct{}
+	closeOnce     sync.Once
+	mu            sync.Mutex
+	conns         map[net.Conn]connState
 }
 
+type connState int
+
+const (
+	connStateIdle        connState = 0
+	connStateActive      connState = 1
+	shutdownPollInterval           = 10 * time.Millisecond
+	serverFieldName                = "Server"
+	headMethod                     = "HEAD"
+)
+
 type HandlerError struct {
 	Status  response.StatusCode
 	Message string
@@ -24,70 +53,296 @@ func (h HandlerError) isError() bool {
 	return h.Status != response.HTTPOk
 }
 
-var closed atomic.Bool
-
 type Handler func(w *response.Writer, req *request.Request) *HandlerError
 
+// Serve listens on every interface at port and serves h.
 func Serve(port int, h Handler) (*Server, error) {
-	portStr := fmt.Sprintf(":%d", port)
-	listener, err := net.Listen("tcp", portStr)
-	if err != nil {
-		return nil, err
+	return Start(Config{Addr: fmt.Sprintf(":%d", port), Handler: h})
+}
+
+// Start listens as described by cfg and serves in the background until Close
+// or Shutdown is called.
+func Start(cfg Config) (*Server, error) {
+	if cfg.Handler == nil {
+		return nil, fmt.Errorf("no handler configured")
 	}
-	closed.Store(false)
-	server := Server{listener: listener, handler: h}
+	listener := cfg.Listener
+	if listener == nil {
+		var err error
+		listener, err = net.Listen("tcp", cfg.Addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	server := newServer(listener, cfg)
 	go server.listen()
-	return &server, nil
+	return server, nil
+}
+
+func newServer(listener net.Listener, cfg Config) *Server {
+	s := &Server{
+		listener:      listener,
+		handler:       cfg.Handler,
+		logger:        cfg.Logger,
+		limits:        cfg.Limits,
+		parseMode:     cfg.ParseMode,
+		timeouts:      cfg.Timeouts,
+		errorRenderer: cfg.ErrorRenderer,
+		serverName:    cfg.ServerName,
+		done:          make(chan struct{}),
+		conns:         make(map[net.Conn]connState),
+	}
+	if s.logger == nil {
+		s.logger = log.Default()
+	}
+	if s.errorRenderer == nil {
+		s.errorRenderer = (*HandlerError).WriteError
+	}
+	if cfg.MaxConns > 0 {
+		s.slots = make(chan struct{}, cfg.MaxConns)
+	}
+	return s
+}
+
+// Addr returns the address the server is listening on, which tells the port
+// chosen when it was started on port 0.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
 }
 
+// Close stops accepting and closes every connection at once, including those
+// in the middle of a request.
 func (s *Server) Close() error {
-	closed.Store(true)
+	err := s.stopListening()
+	s.closeConns(true)
+	return err
+}
+
+// Shutdown stops accepting, closes idle connections and waits for the active
+// ones to finish their current request. If ctx ends first the remaining
+// connections are closed and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.stopListening()
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !s.closeConns(false) {
+		select {
+		case <-ctx.Done():
+			s.closeConns(true)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return err
+}
+
+func (s *Server) stopListening() error {
+	s.closed.Store(true)
+	s.closeOnce.Do(func() { close(s.done) })
 	return s.listener.Close()
 }
 
+// closeConns closes idle connections, or all of them if force is set, and
+// reports whether none are left.
+func (s *Server) closeConns(force bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn, state := range s.conns {
+		if force || state == connStateIdle {
+			conn.Close()
+		}
+	}
+	return len(s.conns) == 0
+}
+
+// track records the state of conn, closing it instead if the server is
+// shutting down and conn has nothing in progress.
+func (s *Server) track(conn net.Conn, state connState) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed.Load() && state == connStateIdle {
+		conn.Close()
+		delete(s.conns, conn)
+		return false
+	}
+	s.conns[conn] = state
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
 func (s *Server) listen() {
 	for {
+		if !s.acquireSlot() {
+			return
+		}
 		conn, err := s.listener.Accept()
 		if err != nil {
-			if closed.Load() {
-				break
+			s.releaseSlot()
+			if s.closed.Load() {
+				return
 			}
-			log.Printf("error accepting connection: %v", err)
+			s.logger.Printf("error accepting connection: %v", err)
 			continue
 		}
-		go s.handle(conn)
+		if s.track(conn, connStateIdle) {
+			go s.handle(conn)
+		} else {
+			s.releaseSlot()
+		}
+	}
+}
+
+// acquireSlot waits until fewer than MaxConns connections are open. It
+// returns false if the server stops in the meantime.
+func (s *Server) acquireSlot() bool {
+	if s.slots == nil {
+		return true
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+func (s *Server) releaseSlot() {
+	if s.slots != nil {
+		<-s.slots
 	}
 }
 
 func (s *Server) handle(conn net.Conn) {
+	defer s.releaseSlot()
 	defer conn.Close() //no net.Conn gets out alive
-	req, err := request.RequestFromReader(conn)
-	writer := response.NewWriter(conn)
-	if err != nil {
-		hErr := &HandlerError{Status: response.HTTPBadRequest, Message: err.Error()}
-		hErr.WriteError(writer)
-		return
-	}
-	handErr := s.handler(writer, req)
-	if handErr != nil {
-		handErr.WriteError(writer)
-		return
+	defer s.untrack(conn)
+	reader := request.NewReader(conn)
+	reader.SetLimits(s.limits)
+	reader.SetParseMode(s.parseMode)
+	timeouts := s.timeouts
+	wait := timeouts.readHeader()
+	for {
+		conn.SetReadDeadline(deadline(time.Now(), wait))
+		if err := reader.Wait(); err != nil {
+			// closed or idle too long before sending anything, nothing to answer
+			return
+		}
+		// a request has begun, so Shutdown waits for it
+		s.track(conn, connStateActive)
+		start := time.Now()
+		conn.SetReadDeadline(deadline(start, timeouts.readHeader()))
+		req, err := reader.ReadRequest()
+		conn.SetWriteDeadline(deadline(time.Now(), timeouts.WriteTimeout))
+		writer := response.NewWriter(conn)
+		if s.serverName != "" {
+			writer.SetHeader(serverFieldName, s.serverName)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			writer.SetKeepAlive(false)
+			s.renderError(s.readError(conn, err), writer)
+			return
+		}
+		conn.SetReadDeadline(deadline(start, timeouts.ReadTimeout))
+		writer.SetKeepAlive(req.KeepAlive() && !s.closed.Load())
+		writer.SetAcceptTrailers(req.AcceptsTrailers())
+		writer.SetHTTP10(req.HTTP10())
+		writer.SetHeadRequest(req.RequestLine.Method == headMethod)
+		handErr := s.handler(writer, req)
+		req.BodyReader.Close()
+		if bodyErr := req.BodyError(); bodyErr != nil {
+			// the rest of the connection cannot be read as the next request
+			writer.SetKeepAlive(false)
+			if !writer.Sent() {
+				handErr = s.readError(conn, bodyErr)
+			}
+		}
+		if handErr != nil && writer.Sent() {
+			// the response is already under way, so the error cannot be
+			// sent and the client has to see the connection drop instead
+			s.logger.Printf("%s %s: %d %s after the response started", req.RequestLine.Method, req.RequestLine.RequestTarget, handErr.Status, handErr.Message)
+			return
+		}
+		if handErr != nil {
+			// whatever the handler left in the buffer gives way to the error
+			writer.Reset()
+			if !s.renderError(handErr, writer) {
+				return
+			}
+		}
+		if err := writer.Finish(); err != nil || !writer.KeepAlive() {
+			return
+		}
+		if !s.track(conn, connStateIdle) {
+			return
+		}
+		wait = timeouts.idle()
 	}
 }
 
-func (e *HandlerError) WriteError(w *response.Writer) {
-	err := w.WriteStatusLine(e.Status)
-	if err != nil {
-		fmt.Println(err.Error())
+// renderError writes the response for e and finishes it, logging and
+// reporting false if that fails.
+func (s *Server) renderError(e *HandlerError, w *response.Writer) bool {
+	err := s.errorRenderer(e, w)
+	if err == nil {
+		err = w.Finish()
 	}
-	header := response.GetDefaultHeaders(len(e.Message))
-	header.SetContextType(headers.ContentTypeTextHTML)
-	err = w.WriteHeaders(header)
 	if err != nil {
-		fmt.Println(err.Error())
+		s.logger.Printf("writing %d response: %v", e.Status, err)
+		return false
 	}
-	_, err = w.WriteBody([]byte(e.Message))
-	if err != nil {
-		fmt.Println(err.Error())
+	return true
+}
+
+// readError logs why a request could not be read and returns the error to
+// answer it with. Its message is fixed by the status, so nothing the client
+// sent is echoed back.
+func (s *Server) readError(conn net.Conn, err error) *HandlerError {
+	s.logger.Printf("reading request from %s: %v", conn.RemoteAddr(), err)
+	status := statusForError(err)
+	return &HandlerError{Status: status, Message: status.Reason()}
+}
+
+// statusForError picks the response status for a request that could not be
+// read.
+func statusForError(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, request.ErrRequestLineTooLong):
+		return response.HTTPURITooLong
+	case errors.Is(err, request.ErrHeadersTooLarge):
+		return response.HTTPRequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return response.HTTPContentTooLarge
+	case errors.Is(err, request.ErrUnsupportedTransferCoding):
+		return response.HTTPNotImplemented
+	case errors.Is(err, request.ErrVersionNotSupported):
+		return response.HTTPVersionNotSupported
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return response.HTTPRequestTimeout
+	default:
+		return response.HTTPBadRequest
+	}
+}
+
+// WriteError writes e as a whole text/html response, with the message
+// escaped, returning the first error met on the way.
+func (e *HandlerError) WriteError(w *response.Writer) error {
+	if err := w.WriteStatusLine(e.Status); err != nil {
+		return err
+	}
+	body := html.EscapeString(e.Message)
+	header := response.GetDefaultHeaders(len(body))
+	header.SetContentType(headers.TextHTML)
+	if err := w.WriteHeaders(header); err != nil {
+		return err
 	}
+	_, err := w.WriteBody([]byte(body))
+	return err
 }
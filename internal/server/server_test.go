@@ -0,0 +1,529 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoTarget(w *response.Writer, req *request.Request) *HandlerError {
+	body := req.RequestLine.RequestTarget
+	w.WriteStatusLine(response.HTTPOk)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody([]byte(body))
+	return nil
+}
+
+// serveConn runs the server side of a net.Pipe and returns the client side
+func serveConn(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	client, conn := net.Pipe()
+	s.track(conn, connStateIdle)
+	go s.handle(conn)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestKeepAlive(t *testing.T) {
+	// Test: Pipelined requests answered in order on one connection
+	client := serveConn(t, newServer(nil, Config{Handler: echoTarget}))
+	go io.WriteString(client, "GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /two HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /three HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	responses := bufio.NewReader(client)
+	for _, target := range []string{"/one", "/two", "/three"} {
+		resp, err := http.ReadResponse(responses, nil)
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, target, string(body))
+		assert.Equal(t, target == "/three", resp.Close)
+	}
+	_, err := responses.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Pipelined HEAD answered with the framing but no body
+	client = serveConn(t, newServer(nil, Config{Handler: echoTarget}))
+	go io.WriteString(client, "HEAD /a HTTP/1.1\r\nHost: localhost\r\n\r\n"+
+		"GET /b HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	responses = bufio.NewReader(client)
+	resp, err := http.ReadResponse(responses, &http.Request{Method: http.MethodHead})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), resp.ContentLength)
+	resp, err = http.ReadResponse(responses, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "/b", string(body))
+
+	// Test: Response without a length gets one and keeps the connection
+	client = serveConn(t, newServer(nil, Config{Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+		w.WriteStatusLine(response.HTTPOk)
+		w.WriteHeaders(response.GetDefaultHeaders(-1))
+		w.Write([]byte("no length "))
+		w.Write([]byte("given"))
+		return nil
+	}}))
+	go io.WriteString(client, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\nGET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	raw, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(raw), "HTTP/1.1 200 OK"))
+	assert.Equal(t, 2, strings.Count(string(raw), "Content-Length: 15\r\n"))
+	assert.Equal(t, 1, strings.Count(string(raw), "Connection: close\r\n"))
+
+	// Test: Handler that writes nothing answered with an empty 200
+	client = serveConn(t, newServer(nil, Config{Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+		return nil
+	}}))
+	go io.WriteString(client, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\nGET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	responses = bufio.NewReader(client)
+	for range 2 {
+		resp, err := http.ReadResponse(responses, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, int64(0), resp.ContentLength)
+	}
+}
+
+func TestHandlerErrorAfterWrite(t *testing.T) {
+	// Test: Error before anything was sent replaces the response
+	for _, write := range []func(w *response.Writer){
+		func(w *response.Writer) { w.WriteHeader(response.HTTPOk) },
+		func(w *response.Writer) { w.Write([]byte("partial")) },
+	} {
+		client := serveConn(t, newServer(nil, Config{Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Header().Set("X-Handler", "1")
+			write(w)
+			return &HandlerError{Status: response.HTTPInternalServerError, Message: "boom"}
+		}}))
+		go io.WriteString(client, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("X-Handler"))
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "boom", string(body))
+	}
+
+	// Test: Error after the response started drops the connection
+	for _, size := range []int{7, 5000} {
+		client := serveConn(t, newServer(nil, Config{Logger: log.New(io.Discard, "", 0), Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+			w.Write([]byte(strings.Repeat("x", size)))
+			w.Flush()
+			return &HandlerError{Status: response.HTTPInternalServerError, Message: "boom"}
+		}}))
+		go io.WriteString(client, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		raw, err := io.ReadAll(client)
+		require.NoError(t, err)
+		assert.NotContains(t, string(raw), "boom")
+		assert.LessOrEqual(t, strings.Count(string(raw), "HTTP/1.1"), 1)
+		resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(string(raw))), nil)
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+		}
+		assert.Error(t, err, "a response cut short must not look complete")
+	}
+}
+
+func TestErrorMessages(t *testing.T) {
+	// Test: Handler message is escaped
+	client := serveConn(t, newServer(nil, Config{Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+		return &HandlerError{Status: response.HTTPBadRequest, Message: "<b>bad</b>"}
+	}}))
+	go io.WriteString(client, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "&lt;b&gt;bad&lt;/b&gt;", string(body))
+
+	// Test: Unreadable request answered without echoing it, and logged
+	var logged strings.Builder
+	client = serveConn(t, newServer(nil, Config{Logger: log.New(&logged, "", 0), Handler: echoTarget}))
+	go io.WriteString(client, "GET / HTTP/1.1\r\nHost: a:<script>\r\n\r\n")
+	raw, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), "HTTP/1.1 400 Bad Request\r\n"))
+	assert.True(t, strings.HasSuffix(string(raw), "\r\n\r\nBad Request"))
+	assert.NotContains(t, string(raw), "script")
+	assert.Contains(t, logged.String(), "reading request from")
+}
+
+func TestLimitErrors(t *testing.T) {
+	tests := []struct {
+		request string
+		status  int
+	}{
+		{"GET /" + strings.Repeat("a", 10<<10) + " HTTP/1.1\r\nHost: localhost\r\n\r\n", http.StatusRequestURITooLong},
+		{"GET / HTTP/1.1\r\nHost: localhost\r\nX-Long: " + strings.Repeat("a", 70<<10) + "\r\n\r\n", http.StatusRequestHeaderFieldsTooLarge},
+		{"POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 20000000\r\n\r\n", http.StatusRequestEntityTooLarge},
+		{"GET / HTTP/1.1\r\nHost localhost\r\n\r\n", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		client := serveConn(t, newServer(nil, Config{Handler: echoTarget}))
+		go io.WriteString(client, test.request)
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		require.NoError(t, err)
+		assert.Equal(t, test.status, resp.StatusCode)
+		assert.True(t, resp.Close)
+	}
+
+	// Test: Chunked body growing over the limit while the handler reads it
+	limits := request.DefaultLimits
+	limits.MaxBodyBytes = 8
+	client := serveConn(t, newServer(nil, Config{Limits: limits, Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+		io.ReadAll(req.BodyReader)
+		return nil
+	}}))
+	go io.WriteString(client, "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n"+
+		"5\r\nhello\r\n5\r\nworld\r\n0\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.True(t, resp.Close)
+}
+
+func TestHTTP10(t *testing.T) {
+	// Test: HTTP/1.0 connection closes unless keep-alive is asked for
+	client := serveConn(t, newServer(nil, Config{Handler: echoTarget}))
+	go io.WriteString(client, "GET /one HTTP/1.0\r\nConnection: keep-alive\r\n\r\n"+
+		"GET /two HTTP/1.0\r\n\r\n")
+	raw, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.0 200 OK\r\n", string(raw[:17]))
+	assert.Equal(t, 2, strings.Count(string(raw), "HTTP/1.0 200 OK\r\n"))
+	assert.Equal(t, 1, strings.Count(string(raw), "Connection: keep-alive\r\n"))
+	assert.Equal(t, 1, strings.Count(string(raw), "Connection: close\r\n"))
+	assert.True(t, strings.HasSuffix(string(raw), "/two"))
+
+	// Test: Other major versions answered with 505
+	client = serveConn(t, newServer(nil, Config{Handler: echoTarget}))
+	go io.WriteString(client, "GET / HTTP/2.0\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusHTTPVersionNotSupported, resp.StatusCode)
+}
+
+func TestSmuggling(t *testing.T) {
+	// Test: Ambiguous framing answered once, and what follows never served
+	tests := []struct {
+		head   string
+		status int
+	}{
+		{"Content-Length: 5\r\nContent-Length: 35\r\n", http.StatusBadRequest},
+		{"Content-Length: 5\r\nTransfer-Encoding: chunked\r\n", http.StatusBadRequest},
+		{"Transfer-Encoding: chunked, chunked\r\n", http.StatusBadRequest},
+		{"Transfer-Encoding: gzip, chunked\r\n", http.StatusNotImplemented},
+	}
+	for _, test := range tests {
+		client := serveConn(t, newServer(nil, Config{Handler: echoTarget}))
+		go io.WriteString(client, "POST / HTTP/1.1\r\nHost: localhost\r\n"+test.head+"\r\n"+
+			"0\r\n\r\nGET /smuggled HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		responses := bufio.NewReader(client)
+		resp, err := http.ReadResponse(responses, nil)
+		require.NoError(t, err)
+		assert.Equal(t, test.status, resp.StatusCode, test.head)
+		assert.True(t, resp.Close)
+		io.Copy(io.Discard, resp.Body)
+		_, err = responses.ReadByte()
+		assert.ErrorIs(t, err, io.EOF)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	// Test: Bare LF rejected by default, accepted when lenient
+	for _, mode := range []headers.ParseMode{headers.ParseStrict, headers.ParseLenient} {
+		client := serveConn(t, newServer(nil, Config{Handler: echoTarget, ParseMode: mode}))
+		go io.WriteString(client, "GET /lf HTTP/1.1\nHost: localhost\nConnection: close\n\n")
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		require.NoError(t, err)
+		if mode == headers.ParseStrict {
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			continue
+		}
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "/lf", string(body))
+	}
+}
+
+func dial(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	blocking := func(w *response.Writer, req *request.Request) *HandlerError {
+		if req.RequestLine.RequestTarget == "/block" {
+			started <- struct{}{}
+			<-release
+		}
+		return echoTarget(w, req)
+	}
+
+	// Test: Servers in one process do not share state
+	first, err := Serve(0, blocking)
+	require.NoError(t, err)
+	second, err := Serve(0, blocking)
+	require.NoError(t, err)
+	defer second.Close()
+	require.NoError(t, first.Close())
+	conn := dial(t, second)
+	io.WriteString(conn, "GET /alive HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Test: Idle connections closed, active ones allowed to finish
+	s, err := Serve(0, blocking)
+	require.NoError(t, err)
+	idle := dial(t, s)
+	io.WriteString(idle, "GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	idleResponses := bufio.NewReader(idle)
+	resp, err = http.ReadResponse(idleResponses, nil)
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+	active := dial(t, s)
+	io.WriteString(active, "GET /block HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+	done := make(chan error)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	_, err = idleResponses.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	select {
+	case <-done:
+		t.Fatal("Shutdown returned with a handler still running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	activeResponses := bufio.NewReader(active)
+	resp, err = http.ReadResponse(activeResponses, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "/block", string(body))
+	_, err = activeResponses.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	require.NoError(t, <-done)
+	_, err = net.Dial("tcp", s.Addr().String())
+	assert.Error(t, err)
+
+	// Test: Request that has begun to arrive is let finish
+	s, err = Serve(0, blocking)
+	require.NoError(t, err)
+	partial := dial(t, s)
+	io.WriteString(partial, "GET /partial HTTP/1.1\r\n")
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, state := range s.conns {
+			if state == connStateActive {
+				return true
+			}
+		}
+		return false
+	}, time.Second, time.Millisecond)
+	go func() { done <- s.Shutdown(context.Background()) }()
+	time.Sleep(20 * time.Millisecond)
+	io.WriteString(partial, "Host: localhost\r\n\r\n")
+	resp, err = http.ReadResponse(bufio.NewReader(partial), nil)
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "/partial", string(body))
+	require.NoError(t, <-done)
+
+	// Test: Connections force-closed once the context ends
+	release = make(chan struct{})
+	defer close(release)
+	s, err = Serve(0, blocking)
+	require.NoError(t, err)
+	active = dial(t, s)
+	io.WriteString(active, "GET /block HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+	_, err = io.ReadAll(active)
+	require.NoError(t, err)
+}
+
+type slowReader struct {
+	data            string
+	numBytesPerRead int
+	delay           time.Duration
+	pos             int
+}
+
+// Read works like chunkReader in the request tests but sleeps before every
+// read, simulating a client that trickles bytes onto the connection
+func (sr *slowReader) Read(p []byte) (n int, err error) {
+	if sr.pos >= len(sr.data) {
+		return 0, io.EOF
+	}
+	time.Sleep(sr.delay)
+	endIndex := min(sr.pos+sr.numBytesPerRead, len(sr.data))
+	n = copy(p, sr.data[sr.pos:endIndex])
+	sr.pos += n
+	return n, nil
+}
+
+func TestTimeouts(t *testing.T) {
+	// Test: Headers trickled in slower than ReadHeaderTimeout get a 408
+	s := newServer(nil, Config{Handler: echoTarget, Timeouts: Timeouts{ReadHeaderTimeout: 50 * time.Millisecond}})
+	client := serveConn(t, s)
+	go io.Copy(client, &slowReader{data: "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", numBytesPerRead: 1, delay: 10 * time.Millisecond})
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestTimeout, resp.StatusCode)
+	assert.True(t, resp.Close)
+
+	// Test: Headers trickled in within ReadHeaderTimeout are served
+	s = newServer(nil, Config{Handler: echoTarget, Timeouts: Timeouts{ReadHeaderTimeout: time.Second}})
+	client = serveConn(t, s)
+	go io.Copy(client, &slowReader{data: "GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n", numBytesPerRead: 3, delay: time.Millisecond})
+	resp, err = http.ReadResponse(bufio.NewReader(client), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Test: Silent connection closed without a response
+	s = newServer(nil, Config{Handler: echoTarget, Timeouts: Timeouts{ReadTimeout: 30 * time.Millisecond}})
+	client = serveConn(t, s)
+	raw, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Empty(t, raw)
+
+	// Test: Kept-alive connection closed after IdleTimeout
+	s = newServer(nil, Config{Handler: echoTarget, Timeouts: Timeouts{IdleTimeout: 30 * time.Millisecond}})
+	client = serveConn(t, s)
+	go io.WriteString(client, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	responses := bufio.NewReader(client)
+	resp, err = http.ReadResponse(responses, nil)
+	require.NoError(t, err)
+	io.ReadAll(resp.Body)
+	start := time.Now()
+	_, err = responses.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	// Test: Body trickled in slower than ReadTimeout fails the handler's read
+	bodyErr := make(chan error, 1)
+	s = newServer(nil, Config{
+		Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+			_, err := io.ReadAll(req.BodyReader)
+			bodyErr <- err
+			return &HandlerError{Status: response.HTTPRequestTimeout, Message: "Request Timeout"}
+		},
+		Timeouts: Timeouts{ReadHeaderTimeout: time.Second, ReadTimeout: 50 * time.Millisecond},
+	})
+	client = serveConn(t, s)
+	go io.Copy(client, &slowReader{data: "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\n0123456789", numBytesPerRead: 5, delay: 20 * time.Millisecond})
+	assert.ErrorIs(t, <-bodyErr, os.ErrDeadlineExceeded)
+
+	// Test: Response the client never reads fails after WriteTimeout
+	writeErr := make(chan error, 1)
+	s = newServer(nil, Config{
+		Handler: func(w *response.Writer, req *request.Request) *HandlerError {
+			w.WriteStatusLine(response.HTTPOk)
+			w.WriteHeaders(response.GetDefaultHeaders(2))
+			_, err := w.WriteBody([]byte("ok"))
+			writeErr <- err
+			return nil
+		},
+		Timeouts: Timeouts{WriteTimeout: 30 * time.Millisecond},
+	})
+	client = serveConn(t, s)
+	io.WriteString(client, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.ErrorIs(t, <-writeErr, os.ErrDeadlineExceeded)
+	raw, err = io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Empty(t, raw)
+}
+
+func TestStart(t *testing.T) {
+	// Test: Handler required
+	_, err := Start(Config{Addr: "127.0.0.1:0"})
+	require.Error(t, err)
+
+	// Test: System-picked port reported by Addr
+	s, err := Start(Config{Addr: "127.0.0.1:0", Handler: echoTarget})
+	require.NoError(t, err)
+	defer s.Close()
+	assert.NotEqual(t, 0, s.Addr().(*net.TCPAddr).Port)
+	conn := dial(t, s)
+	io.WriteString(conn, "GET /picked HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Test: Supplied listener, limits and error renderer
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s, err = Start(Config{
+		Listener:   listener,
+		Handler:    echoTarget,
+		Limits:     request.Limits{MaxRequestLineBytes: 16},
+		ServerName: "httpfromtcp",
+		ErrorRenderer: func(e *HandlerError, w *response.Writer) error {
+			if err := w.WriteHeader(e.Status); err != nil {
+				return err
+			}
+			_, err := io.WriteString(w, "custom")
+			return err
+		},
+	})
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, listener.Addr(), s.Addr())
+	conn = dial(t, s)
+	io.WriteString(conn, "GET /a/rather/long/path HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestURITooLong, resp.StatusCode)
+	assert.Equal(t, "httpfromtcp", resp.Header.Get("Server"))
+	_, err = http.ParseTime(resp.Header.Get("Date"))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "custom", string(body))
+
+	// Test: Connections past MaxConns wait until one closes
+	s, err = Start(Config{Addr: "127.0.0.1:0", Handler: echoTarget, MaxConns: 1})
+	require.NoError(t, err)
+	defer s.Close()
+	first := dial(t, s)
+	io.WriteString(first, "GET /first HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	_, err = http.ReadResponse(bufio.NewReader(first), nil)
+	require.NoError(t, err)
+	second := dial(t, s)
+	io.WriteString(second, "GET /second HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	second.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	secondResponses := bufio.NewReader(second)
+	_, err = secondResponses.ReadByte()
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	first.Close()
+	second.SetReadDeadline(time.Time{})
+	resp, err = http.ReadResponse(secondResponses, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}